@@ -0,0 +1,82 @@
+package seabird
+
+import (
+	"sort"
+	"strings"
+)
+
+// registerAdminCommands sets up the built in commands for managing the bot
+// at runtime. They are only registered if admincommands is set, in which case
+// the plugin and rehash commands are reserved and plugins shouldn't register
+// commands with those names.
+func (b *Bot) registerAdminCommands() {
+	pluginArgs := []Arg{{Name: "name"}}
+
+	b.commandMux.Event("plugin list", b.pluginListCommand, &HelpInfo{
+		Description: "Lists loaded plugins",
+		Permission:  PermissionAdmin,
+	})
+	b.commandMux.Event("plugin load", b.pluginActionCommand("load", "Loaded", b.LoadPlugin), &HelpInfo{
+		Description: "Loads a plugin",
+		Args:        pluginArgs,
+		Permission:  PermissionAdmin,
+	})
+	b.commandMux.Event("plugin unload", b.pluginActionCommand("unload", "Unloaded", b.UnloadPlugin), &HelpInfo{
+		Description: "Unloads a plugin",
+		Args:        pluginArgs,
+		Permission:  PermissionAdmin,
+	})
+	b.commandMux.Event("plugin reload", b.pluginActionCommand("reload", "Reloaded", b.ReloadPlugin), &HelpInfo{
+		Description: "Reloads a plugin",
+		Args:        pluginArgs,
+		Permission:  PermissionAdmin,
+	})
+	b.commandMux.Event("rehash", b.rehashCommand, &HelpInfo{
+		Description: "Reloads the config file",
+		Permission:  PermissionAdmin,
+	})
+}
+
+func (b *Bot) pluginListCommand(r *Request) {
+	r.MentionReplyf("Loaded plugins: %s", strings.Join(b.LoadedPlugins(), ", "))
+
+	failedPlugins := b.FailedPlugins()
+
+	failed := make([]string, 0, len(failedPlugins))
+	for name := range failedPlugins {
+		failed = append(failed, name)
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		r.MentionReplyf("Failed plugins: %s", strings.Join(failed, ", "))
+	}
+}
+
+func (b *Bot) pluginActionCommand(action, done string, f func(name string) error) HandlerFunc {
+	return func(r *Request) {
+		name := r.Args().Get("name")
+
+		err := f(name)
+		if err != nil {
+			r.GetLogger("admin").WithError(err).Warnf("Failed to %s plugin %q", action, name)
+			r.MentionReplyf("Failed to %s %s: %s", action, name, err)
+
+			return
+		}
+
+		r.MentionReplyf("%s plugin %s", done, name)
+	}
+}
+
+func (b *Bot) rehashCommand(r *Request) {
+	err := b.ReloadConfigFile()
+	if err != nil {
+		r.GetLogger("admin").WithError(err).Warn("Failed to reload config")
+		r.MentionReplyf("Failed to reload config: %s", err)
+
+		return
+	}
+
+	r.MentionReplyf("Config reloaded")
+}
@@ -0,0 +1,478 @@
+package seabird
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/belak/go-seabird/internal"
+)
+
+const contextKeyArgs = internal.ContextKey("seabird-args")
+
+// ArgType determines how a command argument or flag is parsed.
+type ArgType int
+
+const (
+	// ArgString is a single word or a double quoted string.
+	ArgString ArgType = iota
+
+	// ArgInt is a whole number.
+	ArgInt
+
+	// ArgDuration is a duration like 5m or 1h30m.
+	ArgDuration
+
+	// ArgNick is a valid IRC nick.
+	ArgNick
+
+	// ArgChannel is a channel name, using the server's channel types.
+	ArgChannel
+
+	// ArgText is the rest of the line exactly as it was sent. It can only be
+	// used for the last argument.
+	ArgText
+
+	// ArgBool is only valid for flags. It is true if the flag was given.
+	ArgBool
+)
+
+func (t ArgType) placeholder() string {
+	switch t {
+	case ArgInt:
+		return "int"
+	case ArgDuration:
+		return "duration"
+	case ArgNick:
+		return "nick"
+	case ArgChannel:
+		return "channel"
+	default:
+		return "value"
+	}
+}
+
+// Arg describes a positional argument to a command.
+type Arg struct {
+	Name string
+	Type ArgType
+
+	// Optional arguments may be left off. Only the last arguments can be
+	// optional.
+	Optional bool
+
+	// Variadic allows the last argument to be given multiple times.
+	Variadic bool
+}
+
+// Flag describes a flag which can be passed to a command as --name, --name
+// value or --name=value.
+type Flag struct {
+	Name string
+	Type ArgType
+}
+
+// Args contains the parsed arguments and flags for a command. Values can be
+// accessed by name with the method matching their type.
+type Args struct {
+	values map[string][]string
+}
+
+// Has returns true if the given argument or flag was provided.
+func (a *Args) Has(name string) bool {
+	return len(a.values[name]) > 0
+}
+
+// Get returns the value of the given argument or flag, or an empty string if
+// it wasn't provided. For a variadic argument, this will be the first value.
+func (a *Args) Get(name string) string {
+	if values := a.values[name]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// All returns every value of a variadic argument.
+func (a *Args) All(name string) []string {
+	return a.values[name]
+}
+
+// Int returns the value of an ArgInt argument or flag.
+func (a *Args) Int(name string) int {
+	ret, _ := strconv.Atoi(a.Get(name))
+	return ret
+}
+
+// Duration returns the value of an ArgDuration argument or flag.
+func (a *Args) Duration(name string) time.Duration {
+	ret, _ := time.ParseDuration(a.Get(name))
+	return ret
+}
+
+// Bool returns the value of an ArgBool flag.
+func (a *Args) Bool(name string) bool {
+	ret, _ := strconv.ParseBool(a.Get(name))
+	return ret
+}
+
+// Args returns the parsed arguments for a command. If the command didn't
+// declare any arguments, this will be empty.
+func (r *Request) Args() *Args {
+	args, ok := r.context.Value(contextKeyArgs).(*Args)
+	if !ok {
+		return &Args{}
+	}
+
+	return args
+}
+
+// argToken is a single word from a command line along with where it started
+// so ArgText can use the original text.
+type argToken struct {
+	value string
+	start int
+}
+
+// argTokenizer splits a command line into words as they're needed. Double
+// quotes can be used to include spaces in a word and a backslash escapes the
+// next character inside quotes. Words are only split when asked for so an
+// ArgText argument can take the rest of the line without it being parsed.
+type argTokenizer struct {
+	line string
+	pos  int
+}
+
+// next returns the next word, or false if there are none left.
+func (t *argTokenizer) next() (argToken, bool, error) {
+	var (
+		current strings.Builder
+		start   = -1
+		quoted  bool
+		escaped bool
+	)
+
+	for i, c := range t.line[t.pos:] {
+		i += t.pos
+
+		switch {
+		case escaped:
+			current.WriteRune(c)
+
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			if start == -1 {
+				start = i
+			}
+
+			quoted = !quoted
+		case !quoted && c == ' ':
+			if start != -1 {
+				t.pos = i + 1
+				return argToken{current.String(), start}, true, nil
+			}
+		default:
+			if start == -1 {
+				start = i
+			}
+
+			current.WriteRune(c)
+		}
+	}
+
+	t.pos = len(t.line)
+
+	if quoted {
+		return argToken{}, false, errors.New("unterminated quote")
+	}
+
+	if start == -1 {
+		return argToken{}, false, nil
+	}
+
+	return argToken{current.String(), start}, true, nil
+}
+
+// rest returns everything which hasn't been split yet exactly as it was sent,
+// without any leading or trailing spaces.
+func (t *argTokenizer) rest() (argToken, bool) {
+	start := t.pos
+	for start < len(t.line) && t.line[start] == ' ' {
+		start++
+	}
+
+	t.pos = len(t.line)
+
+	value := strings.TrimSpace(t.line[start:])
+	if value == "" {
+		return argToken{}, false
+	}
+
+	return argToken{value, start}, true
+}
+
+// parseArgs parses a command line using the arguments and flags from the
+// HelpInfo.
+//
+//nolint:funlen
+func parseArgs(r *Request, help *HelpInfo, line string) (*Args, error) {
+	tokens := &argTokenizer{line: line}
+
+	ret := &Args{values: make(map[string][]string)}
+
+	var positional []argToken
+
+	flagsDone := false
+
+	for {
+		// Once we get to an ArgText argument, everything else is part of it,
+		// even if it wouldn't split cleanly into words.
+		if idx := len(positional); idx < len(help.Args) && help.Args[idx].Type == ArgText {
+			if token, ok := tokens.rest(); ok {
+				positional = append(positional, token)
+			}
+
+			break
+		}
+
+		token, ok, err := tokens.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			break
+		}
+
+		if flagsDone || !strings.HasPrefix(token.value, "--") {
+			positional = append(positional, token)
+			continue
+		}
+
+		if token.value == "--" {
+			flagsDone = true
+			continue
+		}
+
+		name := strings.TrimPrefix(token.value, "--")
+		value := ""
+		hasValue := false
+
+		if idx := strings.Index(name, "="); idx >= 0 {
+			name, value, hasValue = name[:idx], name[idx+1:], true
+		}
+
+		flag := help.flag(name)
+		if flag == nil {
+			return nil, fmt.Errorf("unknown flag --%s", name)
+		}
+
+		if flag.Type == ArgBool && !hasValue {
+			value, hasValue = "true", true
+		}
+
+		if !hasValue {
+			valueToken, ok, err := tokens.next()
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				return nil, fmt.Errorf("missing value for --%s", name)
+			}
+
+			value = valueToken.value
+		}
+
+		err = validateArg(r, flag.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for --%s: %w", name, err)
+		}
+
+		ret.values[name] = []string{value}
+	}
+
+	for i, arg := range help.Args {
+		if i >= len(positional) {
+			if !arg.Optional {
+				return nil, fmt.Errorf("missing %s", arg.Name)
+			}
+
+			break
+		}
+
+		values := []string{positional[i].value}
+
+		if arg.Variadic {
+			values = values[:0]
+			for _, token := range positional[i:] {
+				values = append(values, token.value)
+			}
+		}
+
+		for _, value := range values {
+			err := validateArg(r, arg.Type, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", arg.Name, err)
+			}
+		}
+
+		ret.values[arg.Name] = values
+	}
+
+	if len(positional) > len(help.Args) && !help.takesRest() {
+		return nil, errors.New("too many arguments")
+	}
+
+	return ret, nil
+}
+
+// validateArg makes sure the value can be parsed as the given type.
+func validateArg(r *Request, t ArgType, value string) error {
+	switch t {
+	case ArgInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return errors.New("must be a number")
+		}
+	case ArgDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return errors.New("must be a duration like 5m or 1h30m")
+		}
+	case ArgBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return errors.New("must be true or false")
+		}
+	case ArgNick:
+		if !isValidNick(r.ISupport(), value) {
+			return errors.New("must be a nick")
+		}
+	case ArgChannel:
+		if !r.ISupport().IsChannel(value) {
+			return errors.New("must be a channel")
+		}
+	}
+
+	return nil
+}
+
+// isValidNick checks if the given string follows the RFC 2812 nick rules.
+func isValidNick(isupport *ISupport, nick string) bool {
+	if nick == "" || isupport.IsChannel(nick) {
+		return false
+	}
+
+	if isupport.NickLen > 0 && len(nick) > isupport.NickLen {
+		return false
+	}
+
+	for i, c := range nick {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', strings.ContainsRune(`[]\`+"`"+`_^{|}`, c):
+		case (c >= '0' && c <= '9') || c == '-':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *HelpInfo) flag(name string) *Flag {
+	for i := range h.Flags {
+		if h.Flags[i].Name == name {
+			return &h.Flags[i]
+		}
+	}
+
+	return nil
+}
+
+// takesRest returns true if the last argument consumes the rest of the line.
+func (h *HelpInfo) takesRest() bool {
+	if len(h.Args) == 0 {
+		return false
+	}
+
+	last := h.Args[len(h.Args)-1]
+
+	return last.Variadic || last.Type == ArgText
+}
+
+// validateArgs makes sure the declared arguments can be parsed. Nothing can
+// follow a variadic or ArgText argument, and required arguments can't follow
+// optional ones.
+func (h *HelpInfo) validateArgs() error {
+	for i := 1; i < len(h.Args); i++ {
+		prev, arg := h.Args[i-1], h.Args[i]
+
+		switch {
+		case prev.Variadic || prev.Type == ArgText:
+			return fmt.Errorf("argument %s follows %s, which takes the rest of the line", arg.Name, prev.Name)
+		case prev.Optional && !arg.Optional:
+			return fmt.Errorf("required argument %s follows optional argument %s", arg.Name, prev.Name)
+		}
+	}
+
+	return nil
+}
+
+// hasArgs returns true if the command declared any arguments or flags.
+func (h *HelpInfo) hasArgs() bool {
+	return h != nil && (len(h.Args) > 0 || len(h.Flags) > 0)
+}
+
+// generateUsage builds a usage string from the declared arguments and flags.
+func (h *HelpInfo) generateUsage() string {
+	var parts []string
+
+	for _, flag := range h.Flags {
+		if flag.Type == ArgBool {
+			parts = append(parts, "[--"+flag.Name+"]")
+		} else {
+			parts = append(parts, "[--"+flag.Name+"=<"+flag.Type.placeholder()+">]")
+		}
+	}
+
+	for _, arg := range h.Args {
+		name := arg.Name
+		if arg.Variadic || arg.Type == ArgText {
+			name += "..."
+		}
+
+		if arg.Optional {
+			parts = append(parts, "["+name+"]")
+		} else {
+			parts = append(parts, "<"+name+">")
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// argsMiddleware parses the arguments for a command before calling the
+// handler. If they can't be parsed, the user is told what went wrong along
+// with the usage for the command and the handler is not called.
+func (m *CommandMux) argsMiddleware(help *HelpInfo) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *Request) {
+			args, err := parseArgs(r, help, r.Message.Trailing())
+			if err != nil {
+				r.MentionReplyf("%s. Usage: %s%s %s", err, m.PrefixFor(r), help.name, help.Usage)
+				return
+			}
+
+			newRequest := *r
+			newRequest.context = context.WithValue(r.context, contextKeyArgs, args)
+
+			next(&newRequest)
+		}
+	}
+}
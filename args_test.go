@@ -0,0 +1,105 @@
+package seabird_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seabird "github.com/belak/go-seabird"
+)
+
+var argsPlugin = plugin("test/args", func(b *seabird.Bot) error {
+	cm := b.CommandMux()
+
+	cm.Event("remind", func(r *seabird.Request) {
+		args := r.Args()
+		r.Replyf("%s %s %d %t %s", args.Get("nick"), args.Duration("delay"),
+			args.Int("repeat"), args.Bool("private"), args.Get("message"))
+	}, &seabird.HelpInfo{
+		Description: "Reminds someone of something",
+		Args: []seabird.Arg{
+			{Name: "nick", Type: seabird.ArgNick},
+			{Name: "delay", Type: seabird.ArgDuration},
+			{Name: "message", Type: seabird.ArgText},
+		},
+		Flags: []seabird.Flag{
+			{Name: "repeat", Type: seabird.ArgInt},
+			{Name: "private", Type: seabird.ArgBool},
+		},
+	})
+
+	cm.Event("sum", func(r *seabird.Request) {
+		total := 0
+		for _, v := range r.Args().All("numbers") {
+			n, _ := strconv.Atoi(v)
+			total += n
+		}
+
+		r.Replyf("%s%d", r.Args().Get("label"), total)
+	}, &seabird.HelpInfo{
+		Args: []seabird.Arg{
+			{Name: "label", Type: seabird.ArgString},
+			{Name: "numbers", Type: seabird.ArgInt, Variadic: true},
+		},
+	})
+
+	return nil
+})
+
+func TestCommandArgs(t *testing.T) {
+	b := newTestBot(t, "", argsPlugin)
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		`:belak!~belak@host PRIVMSG #chan :!remind belak 5m take a "break"  now`,
+		`:belak!~belak@host PRIVMSG #chan :!remind belak 5m it's 6" tall`,
+		`:belak!~belak@host PRIVMSG #chan :!remind --repeat=3 --private belak 1h hi`,
+		`:belak!~belak@host PRIVMSG #chan :!remind --repeat 2 -- belak 1h --not-a-flag`,
+		`:belak!~belak@host PRIVMSG #chan :!remind belak soon hi`,
+		`:belak!~belak@host PRIVMSG #chan :!remind #chan 5m hi`,
+		`:belak!~belak@host PRIVMSG #chan :!remind --repeat=lots belak 5m hi`,
+		`:belak!~belak@host PRIVMSG #chan :!remind --bogus belak 5m hi`,
+		`:belak!~belak@host PRIVMSG #chan :!sum "total: " 1 2 3`,
+		`:belak!~belak@host PRIVMSG #chan :!sum total`,
+		`:belak!~belak@host PRIVMSG #chan :!sum "total 1`,
+		`:belak!~belak@host PRIVMSG #chan :!help remind`,
+	)
+
+	usage := "Usage: !remind [--repeat=<int>] [--private] <nick> <delay> <message...>"
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :belak 5m0s 0 false take a \"break\"  now",
+		"PRIVMSG #chan :belak 5m0s 0 false it's 6\" tall",
+		"PRIVMSG #chan :belak 1h0m0s 3 true hi",
+		"PRIVMSG #chan :belak 1h0m0s 2 false --not-a-flag",
+		"PRIVMSG #chan :belak: invalid value for delay: must be a duration like 5m or 1h30m. "+usage,
+		"PRIVMSG #chan :belak: invalid value for nick: must be a nick. "+usage,
+		"PRIVMSG #chan :belak: invalid value for --repeat: must be a number. "+usage,
+		"PRIVMSG #chan :belak: unknown flag --bogus. "+usage,
+		"PRIVMSG #chan :total: 6",
+		"PRIVMSG #chan :belak: missing numbers. Usage: !sum <label> <numbers...>",
+		"PRIVMSG #chan :belak: unterminated quote. Usage: !sum <label> <numbers...>",
+		"PRIVMSG #chan :"+usage,
+		"PRIVMSG #chan :Reminds someone of something",
+	)
+}
+
+func TestCommandArgsInvalidSpec(t *testing.T) {
+	b := newTestBot(t, "", plugin("test/args-bad", func(b *seabird.Bot) error {
+		b.CommandMux().Event("bad", func(r *seabird.Request) {}, &seabird.HelpInfo{
+			Args: []seabird.Arg{
+				{Name: "first", Type: seabird.ArgString, Optional: true},
+				{Name: "second", Type: seabird.ArgString},
+			},
+		})
+
+		return nil
+	}))
+
+	_, err := runTestBotErr(t, b)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `required argument second follows optional argument first`)
+	assert.Empty(t, b.LoadedPlugins())
+}
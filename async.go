@@ -0,0 +1,345 @@
+package seabird
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Defaults for the worker pool used by Async handlers.
+const (
+	defaultWorkers     = 4
+	defaultWorkerQueue = 100
+
+	// defaultStopGrace is how long stopping the pool waits for running
+	// handlers to return after their contexts are canceled.
+	defaultStopGrace = 5 * time.Second
+)
+
+var (
+	errPoolFull    = errors.New("worker pool is full")
+	errPoolStopped = errors.New("worker pool is not running")
+)
+
+// AsyncOptions control how an Async handler is run.
+type AsyncOptions struct {
+	// Ordered makes requests with the same target (the channel, or the
+	// sender for private messages) run one at a time in the order they were
+	// received. Other targets are not affected.
+	Ordered bool
+
+	// Timeout is how long the handler has before its context is canceled. If
+	// it is 0, the handlertimeout from the core config is used.
+	Timeout time.Duration
+}
+
+// Async is a Middleware which runs the rest of the handler chain on the bot's
+// worker pool rather than on the goroutine reading from the connection. This
+// is useful for handlers which make network requests or do other slow work.
+//
+// The handler's Request.Context will be canceled when the timeout expires or
+// the connection is closed. If the pool is full, the request is dropped and a
+// warning is logged. Panics are recovered and logged, but no PanicReply is
+// sent.
+func Async(opts AsyncOptions) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *Request) {
+			if r.bot == nil {
+				next(r)
+				return
+			}
+
+			// The request is copied since the original may be reused once
+			// this returns.
+			job := &asyncJob{r: r.Copy(), h: next, handler: r.handler, timeout: opts.Timeout}
+			if job.handler == nil {
+				job.handler = next
+			}
+
+			if opts.Ordered {
+				job.key = asyncKey(r)
+			}
+
+			switch err := r.bot.workers.submit(job); err {
+			case nil:
+			case errPoolStopped:
+				// If nothing is running the pool, we can't do any better than
+				// running it here.
+				next(r)
+			default:
+				r.GetLogger("async").WithError(err).Warnf("Dropped %s request", r.Message.Command)
+			}
+		}
+	}
+}
+
+// asyncKey returns the target used to order requests.
+func asyncKey(r *Request) string {
+	if r.FromChannel() {
+		return r.ISupport().ToLower(r.Message.Params[0])
+	}
+
+	if r.Message.Prefix != nil {
+		return r.ISupport().ToLower(r.Message.Prefix.Name)
+	}
+
+	return ""
+}
+
+// asyncJob is a single request waiting to be handled by the worker pool.
+type asyncJob struct {
+	r *Request
+	h HandlerFunc
+
+	// handler is the HandlerFunc which was registered with the mux. It is
+	// used to attribute panics, since h is usually a middleware closure.
+	handler HandlerFunc
+
+	key     string
+	timeout time.Duration
+}
+
+// workerPool runs Async handlers with a fixed number of goroutines. It is
+// started when a connection is made and stopped when it is closed. It is safe
+// for concurrent use.
+type workerPool struct {
+	lock    sync.Mutex
+	running bool
+
+	workers int
+	limit   int
+	timeout time.Duration
+
+	// grace is how long stop waits for the workers before giving up on
+	// them.
+	grace time.Duration
+
+	// wg and jobs are replaced every time the pool is started. Workers which
+	// didn't stop in time keep the ones they were started with, which is how
+	// they know they've been abandoned.
+	wg   *sync.WaitGroup
+	jobs chan *asyncJob
+
+	// queued is the number of jobs which have been submitted but haven't
+	// started yet, including ones waiting in ordered.
+	queued int
+
+	// ordered contains the jobs waiting for an earlier job with the same key
+	// to finish. A key is present while a job with that key is queued or
+	// running.
+	ordered map[string][]*asyncJob
+
+	// cancels contains the cancel functions for every running job so they
+	// can be stopped when the pool is.
+	cancels map[*asyncJob]context.CancelFunc
+}
+
+func newWorkerPool(workers, limit int, timeout time.Duration) *workerPool {
+	if workers < 1 {
+		workers = defaultWorkers
+	}
+
+	if limit < 1 {
+		limit = defaultWorkerQueue
+	}
+
+	return &workerPool{
+		workers: workers,
+		limit:   limit,
+		timeout: timeout,
+		grace:   defaultStopGrace,
+	}
+}
+
+// setTimeout changes the default timeout for jobs which start after it is
+// called.
+func (p *workerPool) setTimeout(timeout time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.timeout = timeout
+}
+
+// start launches the workers.
+func (p *workerPool) start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.running = true
+	p.wg = &sync.WaitGroup{}
+	p.jobs = make(chan *asyncJob, p.limit)
+	p.queued = 0
+	p.ordered = make(map[string][]*asyncJob)
+	p.cancels = make(map[*asyncJob]context.CancelFunc)
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+
+		go p.work(p.wg, p.jobs)
+	}
+}
+
+// stop cancels any running jobs, drops anything which hasn't started yet and
+// waits up to the grace period for the workers to exit. Handlers which ignore
+// their context are abandoned rather than holding up the bot, and the number
+// of them is returned.
+func (p *workerPool) stop() int {
+	p.lock.Lock()
+
+	if !p.running {
+		p.lock.Unlock()
+		return 0
+	}
+
+	p.running = false
+
+	for _, cancel := range p.cancels {
+		cancel()
+	}
+
+	close(p.jobs)
+
+	wg, grace := p.wg, p.grace
+	p.lock.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return 0
+	case <-timer.C:
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.cancels)
+}
+
+// submit adds a job to the pool. It never blocks.
+func (p *workerPool) submit(job *asyncJob) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.running {
+		return errPoolStopped
+	}
+
+	if p.queued >= p.limit {
+		return errPoolFull
+	}
+
+	p.queued++
+
+	if job.key != "" {
+		if pending, ok := p.ordered[job.key]; ok {
+			p.ordered[job.key] = append(pending, job)
+			return nil
+		}
+
+		p.ordered[job.key] = nil
+	}
+
+	// This can't block because the channel has room for every queued job.
+	p.jobs <- job
+
+	return nil
+}
+
+func (p *workerPool) work(wg *sync.WaitGroup, jobs chan *asyncJob) {
+	defer wg.Done()
+
+	for job := range jobs {
+		// Ordered jobs are run by the same worker one after another so they
+		// can't overtake each other.
+		for job != nil {
+			p.run(jobs, job)
+			job = p.next(jobs, job.key)
+		}
+	}
+}
+
+// current returns true if the pool is running and was started with the given
+// jobs channel. It must be called with the lock held.
+func (p *workerPool) current(jobs chan *asyncJob) bool {
+	return p.running && p.jobs == jobs
+}
+
+// next returns the next job waiting for the given key, if there is one.
+func (p *workerPool) next(jobs chan *asyncJob, key string) *asyncJob {
+	if key == "" {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// An abandoned worker mustn't touch the state of a newer connection.
+	if p.jobs != jobs {
+		return nil
+	}
+
+	pending := p.ordered[key]
+	if len(pending) == 0 || !p.running {
+		delete(p.ordered, key)
+		return nil
+	}
+
+	p.ordered[key] = pending[1:]
+
+	return pending[0]
+}
+
+func (p *workerPool) run(jobs chan *asyncJob, job *asyncJob) {
+	p.lock.Lock()
+
+	// Anything still waiting when the pool was stopped was meant for the old
+	// connection.
+	if !p.current(jobs) {
+		p.lock.Unlock()
+		return
+	}
+
+	p.queued--
+
+	timeout := job.timeout
+	if timeout == 0 {
+		timeout = p.timeout
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(job.r.context, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(job.r.context)
+	}
+
+	p.cancels[job] = cancel
+	p.lock.Unlock()
+
+	defer func() {
+		p.lock.Lock()
+		delete(p.cancels, job)
+		p.lock.Unlock()
+
+		cancel()
+	}()
+
+	r := *job.r
+	r.context = ctx
+
+	callHandler(&r, job.handler, job.h, false)
+}
@@ -0,0 +1,215 @@
+package seabird
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	irc "gopkg.in/irc.v3"
+)
+
+func asyncRequest(b *Bot, line string) *Request {
+	return NewRequest(context.TODO(), b, "bot", irc.MustParseMessage(line))
+}
+
+func TestAsyncOrdered(t *testing.T) {
+	b := &Bot{workers: newWorkerPool(4, 100, 0)}
+	b.workers.start()
+
+	var (
+		lock sync.Mutex
+		seen = make(map[string][]string)
+		wg   sync.WaitGroup
+	)
+
+	h := Async(AsyncOptions{Ordered: true})(func(r *Request) {
+		defer wg.Done()
+
+		// Make earlier requests slower so they'd finish last if they weren't
+		// ordered.
+		if r.Message.Trailing() == "1" {
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		lock.Lock()
+		seen[r.Message.Params[0]] = append(seen[r.Message.Params[0]], r.Message.Trailing())
+		lock.Unlock()
+	})
+
+	for _, line := range []string{
+		":a!~a@a PRIVMSG #chan 1",
+		":b!~b@b PRIVMSG #other 1",
+		":b!~b@b PRIVMSG #CHAN 2",
+		":a!~a@a PRIVMSG #chan 3",
+		":b!~b@b PRIVMSG #other 2",
+	} {
+		wg.Add(1)
+		h(asyncRequest(b, line))
+	}
+
+	wg.Wait()
+	b.workers.stop()
+
+	assert.Equal(t, map[string][]string{
+		"#chan":  {"1", "3"},
+		"#CHAN":  {"2"},
+		"#other": {"1", "2"},
+	}, seen)
+}
+
+func TestAsyncBounded(t *testing.T) {
+	p := newWorkerPool(1, 2, 0)
+	p.start()
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+
+	slow := func(r *Request) {
+		started <- struct{}{}
+		<-block
+	}
+	r := asyncRequest(nil, ":a!~a@a PRIVMSG #chan :slow")
+
+	// The first job is picked up by the only worker, then 2 more can be
+	// queued and anything past that is dropped.
+	require.NoError(t, p.submit(&asyncJob{r: r, h: slow}))
+	<-started
+
+	assert.NoError(t, p.submit(&asyncJob{r: r, h: slow}))
+	assert.NoError(t, p.submit(&asyncJob{r: r, h: slow}))
+	assert.Equal(t, errPoolFull, p.submit(&asyncJob{r: r, h: slow}))
+
+	close(block)
+	<-started
+	<-started
+	p.stop()
+
+	assert.Equal(t, errPoolStopped, p.submit(&asyncJob{r: r, h: slow}))
+}
+
+func TestAsyncTimeoutAndStop(t *testing.T) {
+	b := &Bot{workers: newWorkerPool(2, 10, time.Hour)}
+	b.workers.start()
+
+	errs := make(chan error, 2)
+
+	Async(AsyncOptions{Timeout: 10 * time.Millisecond})(func(r *Request) {
+		<-r.Context().Done()
+		errs <- r.Context().Err()
+	})(asyncRequest(b, ":a!~a@a PRIVMSG #chan :timeout"))
+
+	require.Equal(t, context.DeadlineExceeded, <-errs)
+
+	// Stopping the pool cancels anything still running.
+	started := make(chan struct{})
+
+	Async(AsyncOptions{})(func(r *Request) {
+		close(started)
+		<-r.Context().Done()
+		errs <- r.Context().Err()
+	})(asyncRequest(b, ":a!~a@a PRIVMSG #chan :stop"))
+
+	<-started
+	b.workers.stop()
+	assert.Equal(t, context.Canceled, <-errs)
+
+	// Once the pool is stopped, handlers are run directly.
+	ran := false
+
+	Async(AsyncOptions{})(func(r *Request) {
+		ran = true
+	})(asyncRequest(b, ":a!~a@a PRIVMSG #chan :direct"))
+
+	assert.True(t, ran)
+}
+
+var asyncPanicStarted = make(chan struct{}, 1)
+
+func asyncPanicHandler(r *Request) {
+	asyncPanicStarted <- struct{}{}
+	panic("boom")
+}
+
+func TestAsyncPanic(t *testing.T) {
+	b := &Bot{
+		workers:     newWorkerPool(1, 10, 0),
+		panicCounts: make(map[string]int),
+	}
+	b.workers.start()
+
+	// Any middleware after Async means it isn't given the registered
+	// handler directly.
+	passthrough := func(next HandlerFunc) HandlerFunc {
+		return func(r *Request) { next(r) }
+	}
+
+	mux := NewBasicMux()
+	mux.Event("PRIVMSG", asyncPanicHandler, Async(AsyncOptions{}), passthrough)
+	mux.HandleEvent(asyncRequest(b, ":a!~a@a PRIVMSG #chan :panic"))
+
+	// Stopping the pool waits for the handler to finish.
+	<-asyncPanicStarted
+	b.workers.stop()
+
+	// The panic should be blamed on the registered handler rather than the
+	// closure Async wraps it in.
+	assert.Equal(t, map[string]int{
+		"github.com/belak/go-seabird.asyncPanicHandler": 1,
+	}, b.HandlerPanics())
+}
+
+func TestAsyncStopAbandonsStuckWorkers(t *testing.T) {
+	p := newWorkerPool(1, 10, 0)
+	p.grace = 20 * time.Millisecond
+	p.start()
+
+	var (
+		lock sync.Mutex
+		ran  []string
+	)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{}, 1)
+
+	record := func(r *Request) {
+		lock.Lock()
+		ran = append(ran, r.Message.Trailing())
+		lock.Unlock()
+
+		done <- struct{}{}
+	}
+
+	// This ignores its context, so stopping the pool can't wait for it.
+	stuck := func(r *Request) {
+		close(started)
+		<-release
+	}
+
+	r := asyncRequest(nil, ":a!~a@a PRIVMSG #chan :old")
+
+	require.NoError(t, p.submit(&asyncJob{r: r, h: stuck, key: "#chan"}))
+	require.NoError(t, p.submit(&asyncJob{r: r, h: record, key: "#chan"}))
+	<-started
+
+	start := time.Now()
+	assert.Equal(t, 1, p.stop())
+	assert.True(t, time.Since(start) < time.Second)
+
+	// Once the stuck handler returns, its worker shouldn't pick up anything
+	// from the old connection or from the restarted pool.
+	p.start()
+	require.NoError(t, p.submit(&asyncJob{r: asyncRequest(nil, ":a!~a@a PRIVMSG #chan :new"), h: record, key: "#chan"}))
+	<-done
+
+	close(release)
+	assert.Equal(t, 0, p.stop())
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(t, []string{"new"}, ran)
+}
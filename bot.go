@@ -3,12 +3,17 @@ package seabird
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/sirupsen/logrus"
 	irc "gopkg.in/irc.v3"
 
@@ -31,16 +36,247 @@ type coreConfig struct {
 	TLSCert     string
 	TLSKey      string
 
-	Cmds   []string
-	Prefix string
+	Cmds            []string
+	Prefix          string
+	ChannelPrefixes map[string][]string
+	MentionPrefix   bool
+	SuggestCommands bool
 
-	Plugins []string
+	Plugins         []string
+	DisabledPlugins []string `toml:"disabled_plugins"`
+	NonFatalPlugins bool
 
-	Debug    bool
-	LogLevel string
+	Debug        bool
+	LogLevel     string
+	StrictConfig bool
 
 	SendLimit internal.Duration
 	SendBurst int
+
+	Admins        []string
+	AdminCommands bool
+	Roles         map[string]roleConfig
+
+	// roles is every role with its matchers compiled, including the one
+	// built from Admins. It's filled in by validate.
+	roles []roleConfig
+
+	RateLimits map[string]rateLimitConfig
+
+	Workers        int
+	WorkerQueue    int
+	HandlerTimeout internal.Duration
+
+	MaxReplyLines int
+	PanicReply    string
+	BulkMaxAge    internal.Duration
+
+	SASLMechanism string
+	SASLUser      string
+	SASLPass      string
+
+	NoReconnect          bool
+	ReconnectDelay       internal.Duration
+	ReconnectMaxDelay    internal.Duration
+	ReconnectJitter      float64
+	ReconnectMaxAttempts int
+}
+
+// configureCommandMux applies the prefix, suggestion and rate limit settings
+// to the CommandMux.
+func (b *Bot) configureCommandMux(config coreConfig) {
+	b.commandMux.SetPrefix(config.Prefix)
+	b.commandMux.SetChannelPrefixes(config.ChannelPrefixes)
+	b.commandMux.SetMentionPrefix(config.MentionPrefix)
+	b.commandMux.SetSuggestions(config.SuggestCommands)
+
+	limits := make(map[string]RateLimit, len(config.RateLimits))
+	for command, limit := range config.RateLimits {
+		limits[command] = limit.rateLimit()
+	}
+
+	b.commandMux.SetRateLimits(limits)
+}
+
+// defaultCoreConfig returns the values used for any core settings which are
+// not specified in the config file.
+func defaultCoreConfig() coreConfig {
+	return coreConfig{
+		Prefix:            "!",
+		ReconnectDelay:    internal.Duration{Duration: time.Second},
+		ReconnectMaxDelay: internal.Duration{Duration: 5 * time.Minute},
+		ReconnectJitter:   0.2,
+	}
+}
+
+// decodeConfig parses a config file and decodes and validates the core
+// section.
+func decodeConfig(confReader io.Reader) (*configData, coreConfig, error) {
+	config := defaultCoreConfig()
+
+	conf, err := parseConfig(confReader)
+	if err != nil {
+		return nil, config, err
+	}
+
+	err = conf.decode("core", &config)
+	if err != nil {
+		return nil, config, err
+	}
+
+	err = config.validate()
+	if err != nil {
+		return nil, config, err
+	}
+
+	return conf, config, nil
+}
+
+// validate checks the core config for missing or invalid values.
+//
+//nolint:funlen
+func (c *coreConfig) validate() error {
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"nick", c.Nick},
+		{"user", c.User},
+		{"name", c.Name},
+	}
+
+	for _, field := range required {
+		if field.value == "" {
+			return newConfigError("core", field.key, "required")
+		}
+	}
+
+	if c.Prefix == "" {
+		return newConfigError("core", "prefix", "must not be empty")
+	}
+
+	c.roles = make([]roleConfig, 0, len(c.Roles)+1)
+
+	for name, role := range c.Roles {
+		if err := role.validate(name); err != nil {
+			return err
+		}
+
+		c.roles = append(c.roles, role)
+	}
+
+	if len(c.Admins) > 0 {
+		admin, err := adminRole(c.Admins)
+		if err != nil {
+			return err
+		}
+
+		c.roles = append(c.roles, admin)
+	}
+
+	for command, limit := range c.RateLimits {
+		if err := limit.validate(command); err != nil {
+			return err
+		}
+	}
+
+	for channel, prefixes := range c.ChannelPrefixes {
+		for _, prefix := range prefixes {
+			if prefix == "" {
+				return newConfigError("core", "channelprefixes", "prefixes for %s must not be empty", channel)
+			}
+		}
+	}
+
+	if c.Host != "" {
+		_, port, err := net.SplitHostPort(c.Host)
+		if err != nil {
+			return newConfigError("core", "host", "must be in the form host:port")
+		}
+
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return newConfigError("core", "host", "invalid port %q", port)
+		}
+	}
+
+	if c.TLSCert != "" && c.TLSKey == "" {
+		return newConfigError("core", "tlskey", "required when tlscert is set")
+	}
+
+	if c.TLSKey != "" && c.TLSCert == "" {
+		return newConfigError("core", "tlscert", "required when tlskey is set")
+	}
+
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			return &ConfigError{"core", "loglevel", err}
+		}
+	}
+
+	nonNegative := []struct {
+		key   string
+		value int
+	}{
+		{"sendburst", c.SendBurst},
+		{"maxreplylines", c.MaxReplyLines},
+		{"reconnectmaxattempts", c.ReconnectMaxAttempts},
+		{"workers", c.Workers},
+		{"workerqueue", c.WorkerQueue},
+	}
+
+	for _, field := range nonNegative {
+		if field.value < 0 {
+			return newConfigError("core", field.key, "must not be negative")
+		}
+	}
+
+	if c.HandlerTimeout.Duration < 0 {
+		return newConfigError("core", "handlertimeout", "must not be negative")
+	}
+
+	if c.ReconnectJitter < 0 || c.ReconnectJitter > 1 {
+		return newConfigError("core", "reconnectjitter", "must be between 0 and 1")
+	}
+
+	switch c.saslMechanism() {
+	case "", "PLAIN":
+	case "EXTERNAL":
+		if c.TLSCert == "" || c.TLSKey == "" {
+			return newConfigError("core", "saslmechanism", "EXTERNAL requires tlscert and tlskey")
+		}
+	default:
+		return newConfigError("core", "saslmechanism", "unsupported SASL mechanism %q", c.SASLMechanism)
+	}
+
+	return nil
+}
+
+// enabledPlugins returns the plugins from the registry enabled by the config
+// in the order they should be loaded. This sorts the plugins so dependency
+// problems are found before any of them are loaded and the load order is
+// always the same.
+func (c *coreConfig) enabledPlugins(plugins *pluginRegistry) ([]string, error) {
+	pluginNames, err := plugins.matching(c.Plugins, c.DisabledPlugins)
+	if err != nil {
+		return nil, err
+	}
+
+	return plugins.sort(pluginNames)
+}
+
+// logLevel returns the configured log level. The config must have already
+// been validated.
+func (c *coreConfig) logLevel() logrus.Level {
+	if c.LogLevel != "" {
+		level, _ := logrus.ParseLevel(c.LogLevel)
+		return level
+	}
+
+	if c.Debug {
+		return logrus.DebugLevel
+	}
+
+	return logrus.InfoLevel
 }
 
 // A Bot is our wrapper around the irc.Client. It could be used for a general
@@ -50,72 +286,145 @@ type Bot struct {
 	mux        *BasicMux
 	commandMux *CommandMux
 	mentionMux *MentionMux
+	tracker    *Tracker
 
 	// Config stuff
-	confValues map[string]toml.Primitive
-	md         toml.MetaData
+	confPath   string
+	configLock sync.RWMutex
+	conf       *configData
 	config     coreConfig
 
 	// Internal things
-	client         *irc.Client
+	connLock       sync.RWMutex
+	queue          *outgoingQueue
+	workers        *workerPool
 	log            *logrus.Entry
 	context        context.Context
+	plugins        *pluginRegistry
 	loadedPlugins  map[string]bool
+	failedPlugins  map[string]*PluginError
 	loadingContext []string
+	pluginsLoaded  bool
+	checkOnly      bool
+	pluginStates   map[string]*pluginState
+	pluginLock     sync.Mutex
+	lifecycleLock  sync.Mutex
+
+	panicLock   sync.Mutex
+	panicCounts map[string]int
+
+	// Connection state which needs to survive reconnects
+	rejoin     []string
+	registered bool
+
+	// wait is used to wait between reconnect attempts so tests can control
+	// it.
+	wait func(ctx context.Context, d time.Duration) error
+
+	// Caps requested by plugins and the caps enabled on the current
+	// connection.
+	capLock     sync.RWMutex
+	capRequests []*capRequest
+	enabledCaps map[string]bool
+
+	// Per-connection state
+	isupport   *ISupport
+	selfUser   string
+	selfHost   string
+	caps       *capNegotiation
+	connErr    error
+	cancelConn context.CancelFunc
 }
 
 // NewBot will return a new Bot given an io.Reader pointing to a
 // config file.
 func NewBot(confReader io.Reader) (*Bot, error) {
+	return newBot(confReader, plugins)
+}
+
+// newBot is NewBot, but the bot loads plugins from the given registry.
+func newBot(confReader io.Reader, plugins *pluginRegistry) (*Bot, error) {
 	var err error
 
 	b := &Bot{
+		plugins:       plugins,
 		mux:           NewBasicMux(),
-		confValues:    make(map[string]toml.Primitive),
-		md:            toml.MetaData{},
+		tracker:       newTracker(),
+		isupport:      DefaultISupport(),
+		queue:         newOutgoingQueue(),
 		loadedPlugins: make(map[string]bool),
+		failedPlugins: make(map[string]*PluginError),
+		pluginStates:  make(map[string]*pluginState),
+		panicCounts:   make(map[string]int),
+		wait:          waitContext,
 	}
 
-	// Decode the file, but leave all the config sections intact so we can
-	// decode those later.
-	b.md, err = toml.DecodeReader(confReader, &b.confValues)
+	b.conf, b.config, err = decodeConfig(confReader)
 	if err != nil {
 		return nil, err
 	}
 
-	// Load up the core config
-	err = b.Config("core", &b.config)
-	if err != nil {
-		return nil, err
-	}
+	b.queue.maxBulkAge = b.config.BulkMaxAge.Duration
+	b.queue.setLimit(b.config.SendLimit.Duration, b.config.SendBurst)
+	b.workers = newWorkerPool(b.config.Workers, b.config.WorkerQueue, b.config.HandlerTimeout.Duration)
 
 	// Set up logging/debugging
 	b.log = logrus.NewEntry(logrus.New())
+	b.log.Logger.Level = b.config.logLevel()
 
-	b.log.Logger.Level = logrus.InfoLevel
-	if b.config.LogLevel != "" {
-		level, innerErr := logrus.ParseLevel(b.config.LogLevel)
-		if innerErr != nil {
-			return nil, innerErr
-		}
-
-		b.log.Logger.Level = level
-	} else if b.config.Debug {
+	if b.config.LogLevel == "" && b.config.Debug {
 		b.log.Warn("The Debug config option has been replaced with LogLevel")
-		b.log.Logger.Level = logrus.DebugLevel
+	}
+
+	err = b.checkUnknownKeys(b.conf, b.config.StrictConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	b.commandMux = NewCommandMux(b.config.Prefix)
+	b.configureCommandMux(b.config)
 	b.mentionMux = NewMentionMux()
 
-	b.mux.Event("PRIVMSG", b.commandMux.HandleEvent)
-	b.mux.Event("PRIVMSG", b.mentionMux.HandleEvent)
+	// The tracker needs to be registered first so the state is up to date
+	// by the time any other handlers run.
+	b.tracker.register(b, b.mux)
+
+	b.mux.Event("PRIVMSG", b.handleMessage)
+
+	if b.config.AdminCommands {
+		b.registerAdminCommands()
+	}
+
+	// Now that the core handlers are registered, start tracking which plugin
+	// registers each handler so they can be unloaded.
+	b.mux.onRegister = b.trackRegistration
+	b.commandMux.onRegister = b.trackRegistration
+	b.mentionMux.onRegister = b.trackRegistration
 
 	b.context = withSeabirdValues(context.TODO(), b, b.log)
 
 	return b, nil
 }
 
+// NewBotFromFile will return a new Bot using the config file at the given
+// path. Unlike NewBot, the config can be reloaded with ReloadConfig.
+func NewBotFromFile(filename string) (*Bot, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := NewBot(f)
+	if err != nil {
+		return nil, err
+	}
+
+	b.confPath = filename
+
+	return b, nil
+}
+
 func (b *Bot) Context() context.Context {
 	return b.context
 }
@@ -136,26 +445,113 @@ func (b *Bot) MentionMux() *MentionMux {
 	return b.mentionMux
 }
 
-// Config will decode the config section for the given name into the given
-// interface{}.
-func (b *Bot) Config(name string, c interface{}) error {
-	if v, ok := b.confValues[name]; ok {
-		return b.md.PrimitiveDecode(v, c)
+// HandlerPanics returns how many times each handler has panicked, keyed by the
+// name of the handler function.
+func (b *Bot) HandlerPanics() map[string]int {
+	b.panicLock.Lock()
+	defer b.panicLock.Unlock()
+
+	ret := make(map[string]int, len(b.panicCounts))
+	for k, v := range b.panicCounts {
+		ret[k] = v
 	}
 
-	return fmt.Errorf("Config section for %q missing", name)
+	return ret
+}
+
+func (b *Bot) recordPanic(name string) {
+	b.panicLock.Lock()
+	defer b.panicLock.Unlock()
+
+	b.panicCounts[name]++
+}
+
+// ISupport returns the RPL_ISUPPORT values for the current connection. The
+// returned value must not be modified.
+func (b *Bot) ISupport() *ISupport {
+	b.connLock.RLock()
+	defer b.connLock.RUnlock()
+
+	return b.isupport
+}
+
+func (b *Bot) setISupport(isupport *ISupport) {
+	b.connLock.Lock()
+	b.isupport = isupport
+	b.connLock.Unlock()
+
+	b.tracker.setISupport(isupport)
+}
+
+// Tracker returns the channel and user state tracker for the bot.
+func (b *Bot) Tracker() *Tracker {
+	return b.tracker
+}
+
+// currentConfig returns the core config. It is safe to call while the config
+// is being reloaded. The returned value must not be modified.
+func (b *Bot) currentConfig() coreConfig {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+
+	return b.config
+}
+
+// currentConf returns the parsed config file. It is safe to call while the
+// config is being reloaded.
+func (b *Bot) currentConf() *configData {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+
+	return b.conf
+}
+
+// Config will decode the config section for the given name into the given
+// interface{}. If c is a pointer to a struct, values can be overridden with
+// environment variables or secret files. See the configuration docs for
+// details.
+func (b *Bot) Config(name string, c interface{}) error {
+	return b.currentConf().decode(name, c)
 }
 
 func (b *Bot) handler(c *irc.Client, m *irc.Message) {
-	r := NewRequest(b.context, b, c.CurrentNick(), m)
+	b.handleCap(c, m)
+
+	b.updateSelfPrefix(c, m)
+
+	if m.Command == irc.RPL_ISUPPORT {
+		b.setISupport(b.ISupport().withMessage(m))
+	}
+
+	ctx := context.WithValue(b.context, contextKeyISupport, b.ISupport())
+	if b.caps != nil {
+		ctx = context.WithValue(ctx, contextKeyCaps, b.caps.enabledSnapshot)
+	}
+
+	r := NewRequest(ctx, b, c.CurrentNick(), m)
 
 	// Handle the event and pass it along
 	if r.Message.Command == "001" {
 		b.log.Info("Connected")
 
-		for _, v := range b.config.Cmds {
-			b.client.Write(v)
+		// If we got to registration without finishing the CAP negotiation, the
+		// server most likely doesn't support CAP at all.
+		if !b.caps.done {
+			if b.saslMechanism() != "" {
+				b.failConnection(fmt.Errorf("%w: server does not support CAP", ErrSASLFailed))
+				return
+			}
+
+			b.caps.done = true
 		}
+
+		b.registered = true
+
+		for _, v := range b.currentConfig().Cmds {
+			b.Write(v)
+		}
+
+		b.rejoinChannels()
 	} else if r.Message.Command == "PRIVMSG" {
 		// Clean up CTCP stuff so plugins don't need to parse it manually
 		lastArg := r.Message.Trailing()
@@ -169,50 +565,230 @@ func (b *Bot) handler(c *irc.Client, m *irc.Message) {
 	b.mux.HandleEvent(r)
 }
 
+// handleMessage passes a PRIVMSG to the CommandMux and then the MentionMux.
+// With mentionprefix, "seabird: foo" could be both, so mentions which were
+// handled as a command aren't passed on.
+func (b *Bot) handleMessage(r *Request) {
+	if b.commandMux.handle(r) {
+		return
+	}
+
+	b.mentionMux.HandleEvent(r)
+}
+
 // ConnectAndRun is a convenience function which will pull the connection
-// information out of the config and connect, then call Run.
+// information out of the config and connect, then call Run. If the connection
+// is lost, it will reconnect with an exponential backoff unless NoReconnect is
+// set. Loaded plugins are kept between connections.
 func (b *Bot) ConnectAndRun() error {
-	// The ReadWriteCloser will contain either a *net.Conn or *tls.Conn
-	var (
-		c   io.ReadWriteCloser
-		err error
-	)
-
-	if b.config.TLS {
-		conf := &tls.Config{
-			InsecureSkipVerify: b.config.TLSNoVerify, //nolint:gosec
+	return b.ConnectAndRunContext(context.Background())
+}
+
+// ConnectAndRunContext is the same as ConnectAndRun, but it will close the
+// connection and stop reconnecting when the given context is canceled.
+func (b *Bot) ConnectAndRunContext(ctx context.Context) error {
+	tlsConf, err := b.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	err = b.ensurePluginsLoaded()
+	if err != nil {
+		return err
+	}
+
+	stopWatching := b.watchReloadSignal()
+	defer stopWatching()
+
+	attempts := 0
+
+	for {
+		b.registered = false
+
+		err = b.connectAndRunOnce(ctx, tlsConf)
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
-		if b.config.TLSCert != "" && b.config.TLSKey != "" {
-			var cert tls.Certificate
-			cert, err = tls.LoadX509KeyPair(b.config.TLSCert, b.config.TLSKey)
+		config := b.currentConfig()
+		if config.NoReconnect || errors.Is(err, ErrSASLFailed) || errors.Is(err, ErrCapRequired) {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+		// If we made it through registration, this was a working connection
+		// so we start the backoff over.
+		if b.registered {
+			attempts = 0
+		}
+
+		attempts++
+
+		if config.ReconnectMaxAttempts > 0 && attempts > config.ReconnectMaxAttempts {
+			return fmt.Errorf("giving up after %d reconnect attempts: %w", attempts-1, err)
+		}
+
+		delay := b.reconnectDelay(attempts)
+		b.log.WithError(err).Warnf("Disconnected, reconnecting in %s", delay)
 
-			conf.Certificates = []tls.Certificate{cert}
-			conf.BuildNameToCertificate()
+		if err := b.wait(ctx, delay); err != nil {
+			return err
 		}
+	}
+}
+
+// waitContext waits for the given duration, returning early if the context is
+// canceled.
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
-		c, err = tls.Dial("tcp", b.config.Host, conf)
-	} else {
-		c, err = net.Dial("tcp", b.config.Host)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
+}
 
+// tlsConfig returns the TLS config to use when connecting or nil if TLS is
+// disabled.
+func (b *Bot) tlsConfig() (*tls.Config, error) {
+	config := b.currentConfig()
+	if !config.TLS {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		InsecureSkipVerify: config.TLSNoVerify, //nolint:gosec
+	}
+
+	if config.TLSCert != "" && config.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.Certificates = []tls.Certificate{cert}
+		conf.BuildNameToCertificate()
+	}
+
+	return conf, nil
+}
+
+func (b *Bot) connectAndRunOnce(ctx context.Context, tlsConf *tls.Config) error {
+	host := b.currentConfig().Host
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", host)
 	if err != nil {
 		return err
 	}
 
-	return b.Run(c)
+	// The ReadWriteCloser will contain either a *net.Conn or *tls.Conn
+	var c io.ReadWriteCloser = conn
+
+	if tlsConf != nil {
+		// This matches what tls.Dial does when no ServerName is set.
+		if tlsConf.ServerName == "" {
+			tlsConf = tlsConf.Clone()
+			tlsConf.ServerName, _, _ = net.SplitHostPort(host)
+		}
+
+		tlsConn := tls.Client(conn, tlsConf)
+
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+
+		c = tlsConn
+	}
+
+	return b.runContext(ctx, c)
 }
 
+// reconnectDelay returns how long to wait before the given reconnect attempt.
+// The delay doubles with every attempt up to ReconnectMaxDelay and is then
+// randomly adjusted by up to ReconnectJitter in either direction.
+func (b *Bot) reconnectDelay(attempt int) time.Duration {
+	config := b.currentConfig()
+	delay := config.ReconnectDelay.Duration
+	maxDelay := config.ReconnectMaxDelay.Duration
+
+	for i := 1; i < attempt && (maxDelay <= 0 || delay < maxDelay); i++ {
+		delay *= 2
+	}
+
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if config.ReconnectJitter > 0 {
+		//nolint:gosec
+		delay += time.Duration(float64(delay) * config.ReconnectJitter * (rand.Float64()*2 - 1))
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay
+}
+
+// rejoinChannels joins any channels the bot was in before it was disconnected
+// which weren't already joined by the configured Cmds.
+func (b *Bot) rejoinChannels() {
+	channels := b.rejoin
+	b.rejoin = nil
+
+	cmdChannels := b.cmdChannels()
+
+	for _, channel := range channels {
+		if internal.IsSliceContainsStr(cmdChannels, channel) {
+			continue
+		}
+
+		b.Writef("JOIN :%s", channel)
+	}
+}
+
+// cmdChannels returns all the channels joined by the configured Cmds.
+func (b *Bot) cmdChannels() []string {
+	var ret []string
+
+	for _, cmd := range b.currentConfig().Cmds {
+		m, err := irc.ParseMessage(cmd)
+		if err != nil || m.Command != "JOIN" || len(m.Params) < 1 {
+			continue
+		}
+
+		ret = append(ret, strings.Split(m.Params[0], ",")...)
+	}
+
+	return ret
+}
+
+// EnsurePlugin makes sure the given plugin is loaded, loading it if needed. If
+// the plugin failed to load, the same *PluginError will be returned every time
+// rather than trying to load it again.
 func (b *Bot) EnsurePlugin(name string) error {
 	loaded, ok := b.loadedPlugins[name]
 	if !ok {
 		return fmt.Errorf("Plugin %q not loaded", name)
 	}
 
+	if err, ok := b.failedPlugins[name]; ok {
+		return err
+	}
+
+	// Keep track of which plugins depend on this one so it can't be unloaded
+	// out from under them.
+	if state := b.currentPluginState(); state != nil {
+		state.dependencies = internal.AppendStr(state.dependencies, name)
+	}
+
 	// If it's already loaded, return nil
 	if loaded {
 		return nil
@@ -225,33 +801,128 @@ func (b *Bot) loadPlugin(name string) error {
 	tmpLoadingContext := append(b.loadingContext, name)
 
 	if internal.IsSliceContainsStr(b.loadingContext, name) {
-		return fmt.Errorf(
+		return &PluginError{name, fmt.Errorf(
 			"Plugin load loop: %s",
-			strings.Join(tmpLoadingContext, ", "))
+			strings.Join(tmpLoadingContext, ", "))}
 	}
 
 	// Push the current plugin onto the stack
+	b.pluginLock.Lock()
 	b.loadingContext = tmpLoadingContext
+	b.pluginStates[name] = newPluginState()
+	b.pluginLock.Unlock()
 
 	// Note that this is where it's possible for a plugin to recurse.
 	// EnsurePlugin can be called by Plugins which can in turn call loadPlugin.
-	err := plugins[name](b)
+	err := b.ensureDeclaredDependencies(name)
+	if err == nil {
+		factory := b.plugins.factories[name]
+
+		// When checking a config, plugins which can validate their config
+		// without side effects aren't really loaded.
+		if validate := b.plugins.info[name].Validate; b.checkOnly && validate != nil {
+			factory = validate
+		}
+
+		// Panics, such as from registering a command with an invalid
+		// argument spec, are treated like the plugin returning an error.
+		err = b.callPluginHook(name, func() error {
+			return factory(b)
+		})
+	}
 
 	// Mark the plugin as loaded
 	b.loadedPlugins[name] = true
 
 	// Pop the current plugin off the stack
+	b.pluginLock.Lock()
 	b.loadingContext = b.loadingContext[:len(b.loadingContext)-1]
+	b.pluginLock.Unlock()
 
-	return err
+	if err != nil {
+		// Clean up anything the plugin registered before failing.
+		b.teardownPlugin(name)
+
+		pluginErr := &PluginError{name, err}
+		b.failedPlugins[name] = pluginErr
+
+		return pluginErr
+	}
+
+	return nil
+}
+
+// ensureDeclaredDependencies loads the dependencies a plugin declared in its
+// PluginInfo. This must be called while the plugin is loading so they are
+// recorded as its dependencies.
+func (b *Bot) ensureDeclaredDependencies(name string) error {
+	info := b.plugins.info[name]
+
+	for _, dep := range info.Dependencies {
+		err := b.EnsurePlugin(dep)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dep := range info.OptionalDependencies {
+		if _, ok := b.loadedPlugins[dep]; !ok {
+			continue
+		}
+
+		err := b.EnsurePlugin(dep)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensurePluginsLoaded will load all the configured plugins the first time it is
+// called. Later calls (such as when reconnecting) are no-ops so plugins and
+// their state are kept between connections.
+func (b *Bot) ensurePluginsLoaded() error {
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	if b.pluginsLoaded {
+		return nil
+	}
+
+	err := b.loadPlugins()
+	if err != nil {
+		return err
+	}
+
+	// Now that every plugin has decoded its config, anything left over is
+	// most likely a typo.
+	conf := b.currentConf()
+
+	err = b.checkUnknownKeys(conf, b.currentConfig().StrictConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, section := range conf.unusedSections() {
+		b.log.WithField("section", section).Info("Config section not used by any loaded plugin")
+	}
+
+	b.pluginsLoaded = true
+
+	return nil
 }
 
 func (b *Bot) loadPlugins() error {
-	pluginNames, err := matchingPlugins(b.config.Plugins)
+	config := b.currentConfig()
+
+	pluginNames, err := config.enabledPlugins(b.plugins)
 	if err != nil {
 		return err
 	}
 
+	b.log.Infof("Enabled plugins: %s", strings.Join(pluginNames, ", "))
+
 	// Update the loadedPlugins map to say which ones we're loading.
 	for _, name := range pluginNames {
 		b.loadedPlugins[name] = false
@@ -260,45 +931,61 @@ func (b *Bot) loadPlugins() error {
 	// Loop through all our plugins and load them
 	for _, name := range pluginNames {
 		err = b.EnsurePlugin(name)
-		if err != nil {
+		if err != nil && !config.NonFatalPlugins {
 			return err
 		}
 	}
 
+	for _, name := range pluginNames {
+		if err, ok := b.failedPlugins[name]; ok {
+			b.log.WithError(err).Errorf("Plugin %q has been disabled", name)
+		}
+	}
+
 	return nil
 }
 
 // Run starts the bot and loops until it dies. It accepts a ReadWriter. If you
 // wish to use the connection feature from the config, use ConnectAndRun.
 func (b *Bot) Run(c io.ReadWriteCloser) error {
-	err := b.loadPlugins()
+	return b.runContext(context.Background(), c)
+}
+
+// runContext is Run, but the connection is closed when the given context is
+// canceled.
+func (b *Bot) runContext(parent context.Context, c io.ReadWriteCloser) error {
+	err := b.ensurePluginsLoaded()
 	if err != nil {
 		return err
 	}
 
+	config := b.currentConfig()
+
 	// Create a client from the connection we've just opened
+	//
+	// Note that PASS is sent manually so it can go out before CAP LS and the
+	// send limit is handled by our own outgoing queue.
 	rc := irc.ClientConfig{
-		Nick: b.config.Nick,
-		Pass: b.config.Pass,
-		User: b.config.User,
-		Name: b.config.Name,
+		Nick: config.Nick,
+		User: config.User,
+		Name: config.Name,
 
-		PingFrequency: b.config.PingFrequency.Duration,
-		PingTimeout:   b.config.PingTimeout.Duration,
-
-		SendLimit: b.config.SendLimit.Duration,
-		SendBurst: b.config.SendBurst,
+		PingFrequency: config.PingFrequency.Duration,
+		PingTimeout:   config.PingTimeout.Duration,
 
 		Handler: irc.HandlerFunc(b.handler),
 	}
 
-	b.client = irc.NewClient(c, rc)
+	// The client closes the connection as soon as it stops, so the queue is
+	// flushed right before that happens.
+	conn := &flushingConn{ReadWriteCloser: c}
+	client := irc.NewClient(conn, rc)
 
 	// Now that we have a client, set up debug callbacks
-	b.client.Reader.DebugCallback = func(line string) {
+	client.Reader.DebugCallback = func(line string) {
 		b.log.Debug("<-- ", strings.Trim(line, "\r\n"))
 	}
-	b.client.Writer.DebugCallback = func(line string) {
+	client.Writer.DebugCallback = func(line string) {
 		if len(line) > 512 {
 			b.log.Warnf("Line longer than 512 chars: %s", strings.Trim(line, "\r\n"))
 		}
@@ -306,21 +993,115 @@ func (b *Bot) Run(c io.ReadWriteCloser) error {
 		b.log.Debug("--> ", strings.Trim(line, "\r\n"))
 	}
 
+	// Remember which channels we were in so we can rejoin them, then start
+	// fresh for the new connection. If the last connection never got far
+	// enough to join anything, we keep what we had from the one before.
+	if channels := b.tracker.Channels(); len(channels) > 0 {
+		b.rejoin = channels
+	}
+	b.tracker.reset()
+	b.setISupport(DefaultISupport())
+
+	b.connLock.Lock()
+	b.selfUser, b.selfHost = "", ""
+	b.connLock.Unlock()
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	b.connErr = nil
+	b.cancelConn = cancel
+
+	if config.Pass != "" {
+		client.Writef("PASS :%s", config.Pass)
+	}
+
+	b.startCapNegotiation(client)
+
+	// Anything left in the queue was meant for the previous connection.
+	b.queue.reset()
+
+	b.workers.start()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		b.queue.run(ctx, client)
+	}()
+
+	// Make a best effort attempt to send anything which was queued right
+	// before the connection closed, like a QUIT.
+	conn.flush = func() {
+		cancel()
+		wg.Wait()
+
+		if dropped := b.queue.flush(client); dropped > 0 {
+			b.log.Warnf("Discarded %d queued messages", dropped)
+		}
+	}
+
 	// Start the main loop
-	return b.client.Run()
+	err = client.RunContext(ctx)
+
+	cancel()
+
+	if stuck := b.workers.stop(); stuck > 0 {
+		b.log.Warnf("Abandoned %d async handlers which didn't stop after being canceled", stuck)
+	}
+
+	if b.connErr != nil {
+		return b.connErr
+	}
+
+	return err
 }
 
-// Send is a simple function to send an IRC event.
+// flushingConn is a connection which calls flush before it is closed.
+type flushingConn struct {
+	io.ReadWriteCloser
+
+	flush func()
+}
+
+func (c *flushingConn) Close() error {
+	if c.flush != nil {
+		c.flush()
+	}
+
+	return c.ReadWriteCloser.Close()
+}
+
+// failConnection will close the current connection and cause Run to return
+// the given error.
+func (b *Bot) failConnection(err error) {
+	if b.connErr == nil {
+		b.connErr = err
+	}
+
+	b.cancelConn()
+}
+
+// Send is a simple function to send an IRC event. The message is added to the
+// outgoing queue with the default priority for its command.
 func (b *Bot) WriteMessage(m *irc.Message) {
-	b.client.WriteMessage(m)
+	b.WritePriority(m, defaultPriority(m))
 }
 
 // Write will write an raw IRC message to the stream.
 func (b *Bot) Write(line string) {
-	b.client.Write(line)
+	m, err := irc.ParseMessage(line)
+	if err != nil {
+		b.queue.push(PriorityControl, "", "", line)
+		return
+	}
+
+	b.WriteMessage(m)
 }
 
 // Writef is a convenience method around fmt.Sprintf and Bot.Write.
 func (b *Bot) Writef(format string, args ...interface{}) {
-	b.client.Writef(format, args...)
+	b.Write(fmt.Sprintf(format, args...))
 }
@@ -0,0 +1,318 @@
+package seabird
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	irc "gopkg.in/irc.v3"
+
+	"github.com/belak/go-seabird/internal"
+)
+
+// ErrCapRequired is returned from Run and ConnectAndRun when a cap requested as
+// required could not be negotiated. ConnectAndRun will not reconnect, since
+// the server isn't going to change its mind.
+var ErrCapRequired = errors.New("required CAP could not be negotiated")
+
+// requiredCapError returns the error for a required cap which could not be
+// negotiated. The sasl cap is only required when SASL is configured, so it is
+// treated as a SASL failure.
+func requiredCapError(name, reason string) error {
+	if name == "sasl" {
+		return fmt.Errorf("%w: CAP sasl %s", ErrSASLFailed, reason)
+	}
+
+	return fmt.Errorf("%w: CAP %s %s", ErrCapRequired, name, reason)
+}
+
+// capNegotiation tracks the state of the IRCv3 capability negotiation for a
+// single connection. It is only used from the client's read goroutine, so no
+// locking is needed.
+type capNegotiation struct {
+	// requested maps the name of each requested cap to whether or not it is
+	// required.
+	requested map[string]bool
+
+	// available contains the caps advertised by the server along with their
+	// values.
+	available map[string]string
+
+	// enabled contains all caps the server acknowledged.
+	enabled map[string]bool
+
+	// enabledSnapshot is an immutable copy of enabled which is handed out to
+	// other goroutines and attached to each Request.
+	enabledSnapshot map[string]bool
+
+	// pendingReqs is the number of CAP REQs we are waiting for a response to.
+	pendingReqs int
+
+	// saslStarted is true once we have sent AUTHENTICATE.
+	saslStarted bool
+
+	done bool
+}
+
+func newCapNegotiation(requested map[string]bool) *capNegotiation {
+	return &capNegotiation{
+		requested:       requested,
+		available:       make(map[string]string),
+		enabled:         make(map[string]bool),
+		enabledSnapshot: make(map[string]bool),
+	}
+}
+
+// capRequest is a single call to CapRequest.
+type capRequest struct {
+	name     string
+	required bool
+}
+
+// CapRequest allows plugins to request IRCv3 capabilities from the server
+// during the handshake. It should be called from a PluginFactory and will take
+// effect the next time the bot connects. If the cap is marked as required, the
+// connection will fail if it could not be negotiated. The returned
+// Registration can be used to withdraw the request, which also takes effect
+// on the next connection.
+func (b *Bot) CapRequest(name string, required bool) *Registration {
+	req := &capRequest{name, required}
+
+	b.capLock.Lock()
+	b.capRequests = append(b.capRequests, req)
+	b.capLock.Unlock()
+
+	reg := newRegistration(func() {
+		b.capLock.Lock()
+		defer b.capLock.Unlock()
+
+		for i, r := range b.capRequests {
+			if r == req {
+				b.capRequests = append(b.capRequests[:i:i], b.capRequests[i+1:]...)
+				break
+			}
+		}
+	})
+
+	b.trackRegistration(reg)
+
+	return reg
+}
+
+// CapEnabled returns true if the given cap was acknowledged by the server on
+// the current connection.
+func (b *Bot) CapEnabled(name string) bool {
+	b.capLock.RLock()
+	defer b.capLock.RUnlock()
+
+	return b.enabledCaps[name]
+}
+
+// requestedCaps returns the caps we want to request for the current
+// connection.
+func (b *Bot) requestedCaps() map[string]bool {
+	b.capLock.RLock()
+	defer b.capLock.RUnlock()
+
+	ret := make(map[string]bool)
+
+	for _, req := range b.capRequests {
+		ret[req.name] = ret[req.name] || req.required
+	}
+
+	if b.saslMechanism() != "" {
+		ret["sasl"] = true
+	}
+
+	return ret
+}
+
+// updateEnabledCaps publishes a new snapshot of the enabled caps.
+func (b *Bot) updateEnabledCaps() {
+	snapshot := make(map[string]bool)
+
+	for name, enabled := range b.caps.enabled {
+		if enabled {
+			snapshot[name] = true
+		}
+	}
+
+	b.caps.enabledSnapshot = snapshot
+
+	b.capLock.Lock()
+	b.enabledCaps = snapshot
+	b.capLock.Unlock()
+}
+
+// startCapNegotiation sends the initial CAP LS and sets up the negotiation
+// state. It needs to be called before NICK and USER are sent so the server
+// will hold registration until we send CAP END.
+func (b *Bot) startCapNegotiation(c *irc.Client) {
+	b.caps = newCapNegotiation(b.requestedCaps())
+	b.updateEnabledCaps()
+
+	if len(b.caps.requested) == 0 {
+		b.caps.done = true
+		return
+	}
+
+	c.Write("CAP LS 302")
+}
+
+// handleCap deals with all the messages which are a part of the CAP
+// negotiation, including SASL.
+func (b *Bot) handleCap(c *irc.Client, m *irc.Message) {
+	if b.caps == nil {
+		return
+	}
+
+	// CAP messages can come in after registration with cap-notify, so we
+	// always want to handle those.
+	if m.Command == "CAP" {
+		b.handleCapMessage(c, m)
+		return
+	}
+
+	if b.caps.done {
+		return
+	}
+
+	switch m.Command {
+	case "AUTHENTICATE":
+		b.handleAuthenticate(c, m)
+	case irc.RPL_SASLSUCCESS, irc.ERR_SASLALREADY:
+		b.endCapNegotiation(c)
+	case irc.ERR_SASLFAIL, irc.ERR_SASLTOOLONG, irc.ERR_SASLABORTED, irc.ERR_NICKLOCKED:
+		b.failConnection(fmt.Errorf("%w: %s", ErrSASLFailed, m.Trailing()))
+	}
+}
+
+func (b *Bot) handleCapMessage(c *irc.Client, m *irc.Message) {
+	if len(m.Params) < 3 {
+		return
+	}
+
+	switch m.Params[1] {
+	case "NEW":
+		b.handleCapNew(c, m)
+	case "DEL":
+		for _, name := range strings.Fields(m.Trailing()) {
+			delete(b.caps.available, name)
+			delete(b.caps.enabled, name)
+		}
+
+		b.updateEnabledCaps()
+	case "LS":
+		for _, token := range strings.Fields(m.Trailing()) {
+			kv := strings.SplitN(token, "=", 2)
+			if len(kv) == 2 {
+				b.caps.available[kv[0]] = kv[1]
+			} else {
+				b.caps.available[kv[0]] = ""
+			}
+		}
+
+		// If there's a * before the cap list, there are more lines coming.
+		if len(m.Params) > 3 && m.Params[2] == "*" {
+			return
+		}
+
+		b.requestCaps(c)
+	case "ACK":
+		for _, name := range strings.Fields(m.Trailing()) {
+			b.caps.enabled[strings.TrimPrefix(name, "-")] = !strings.HasPrefix(name, "-")
+		}
+
+		b.updateEnabledCaps()
+
+		b.caps.pendingReqs--
+		b.maybeFinishCaps(c)
+	case "NAK":
+		for _, name := range strings.Fields(m.Trailing()) {
+			if b.caps.requested[name] && !b.caps.done {
+				b.failConnection(requiredCapError(name, "requested but was rejected"))
+				return
+			}
+		}
+
+		b.caps.pendingReqs--
+		b.maybeFinishCaps(c)
+	}
+}
+
+// handleCapNew requests any newly advertised caps we're interested in. This is
+// only sent by servers after registration if cap-notify is enabled, which is
+// implied by CAP LS 302.
+func (b *Bot) handleCapNew(c *irc.Client, m *irc.Message) {
+	var toRequest []string
+
+	for _, token := range strings.Fields(m.Trailing()) {
+		kv := strings.SplitN(token, "=", 2)
+		b.caps.available[kv[0]] = ""
+
+		if len(kv) == 2 {
+			b.caps.available[kv[0]] = kv[1]
+		}
+
+		// SASL is only useful during registration.
+		if _, ok := b.caps.requested[kv[0]]; ok && kv[0] != "sasl" && !b.caps.enabled[kv[0]] {
+			toRequest = append(toRequest, kv[0])
+		}
+	}
+
+	if len(toRequest) > 0 {
+		b.caps.pendingReqs++
+		c.Writef("CAP REQ :%s", strings.Join(toRequest, " "))
+	}
+}
+
+// requestCaps sends a CAP REQ for all the requested caps the server supports.
+func (b *Bot) requestCaps(c *irc.Client) {
+	var toRequest []string
+
+	for name, required := range b.caps.requested {
+		if _, ok := b.caps.available[name]; ok {
+			toRequest = append(toRequest, name)
+		} else if required {
+			b.failConnection(requiredCapError(name, "requested but not supported by the server"))
+			return
+		}
+	}
+
+	if mech := b.saslMechanism(); mech != "" && b.caps.available["sasl"] != "" &&
+		!internal.IsSliceContainsStr(strings.Split(b.caps.available["sasl"], ","), mech) {
+		b.failConnection(fmt.Errorf("%w: mechanism %s not supported by the server", ErrSASLFailed, mech))
+		return
+	}
+
+	// Sort the caps so the request is deterministic.
+	sort.Strings(toRequest)
+
+	if len(toRequest) > 0 {
+		b.caps.pendingReqs++
+		c.Writef("CAP REQ :%s", strings.Join(toRequest, " "))
+	}
+
+	b.maybeFinishCaps(c)
+}
+
+// maybeFinishCaps will either start SASL or end the negotiation once all CAP
+// REQs have been answered.
+func (b *Bot) maybeFinishCaps(c *irc.Client) {
+	if b.caps.done || b.caps.pendingReqs > 0 {
+		return
+	}
+
+	if b.caps.enabled["sasl"] && b.saslMechanism() != "" {
+		b.startSASL(c)
+		return
+	}
+
+	b.endCapNegotiation(c)
+}
+
+func (b *Bot) endCapNegotiation(c *irc.Client) {
+	b.caps.done = true
+	c.Write("CAP END")
+}
@@ -0,0 +1,61 @@
+package seabird_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/belak/go-seabird"
+)
+
+func TestCapRequest(t *testing.T) {
+	capsSeen := make(map[string]bool)
+
+	b := newTestBot(t, "", plugin("test/caps", func(b *seabird.Bot) error {
+		b.CapRequest("server-time", false)
+		b.CapRequest("away-notify", false)
+
+		b.BasicMux().Event("001", func(r *seabird.Request) {
+			capsSeen["server-time"] = r.CapEnabled("server-time")
+			capsSeen["away-notify"] = r.CapEnabled("away-notify")
+		})
+
+		return nil
+	}))
+
+	sent := runTestBot(t, b,
+		"CAP * LS :server-time message-tags",
+		"CAP * ACK :server-time",
+		"001 bot :Welcome",
+	)
+
+	assert.Equal(t, normalizeLines(
+		"CAP LS 302",
+		"NICK :bot",
+		"USER bot 0 * :bot",
+		"CAP REQ :server-time",
+		"CAP END",
+	), sentLines(sent))
+
+	assert.True(t, capsSeen["server-time"])
+	assert.False(t, capsSeen["away-notify"])
+	assert.True(t, b.CapEnabled("server-time"))
+}
+
+func TestCapRequired(t *testing.T) {
+	requiredCap := plugin("test/required-cap", func(b *seabird.Bot) error {
+		b.CapRequest("account-tag", true)
+		return nil
+	})
+
+	for _, lines := range [][]string{
+		{"CAP * LS :account-tag", "CAP * NAK :account-tag", "001 bot :Welcome"},
+		{"CAP * LS :server-time", "001 bot :Welcome"},
+	} {
+		b := newTestBot(t, "", requiredCap)
+
+		_, err := runTestBotErr(t, b, lines...)
+		assert.True(t, errors.Is(err, seabird.ErrCapRequired), "unexpected error %v", err)
+	}
+}
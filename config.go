@@ -0,0 +1,369 @@
+package seabird
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfigError is returned when a config value is invalid.
+type ConfigError struct {
+	Section string
+	Key     string
+	Err     error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Section + "." + e.Key + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func newConfigError(section, key, format string, v ...interface{}) *ConfigError {
+	return &ConfigError{section, key, fmt.Errorf(format, v...)}
+}
+
+// configData holds a parsed config file. Sections are kept as primitives so
+// they can be decoded by plugins later.
+type configData struct {
+	values map[string]toml.Primitive
+	md     toml.MetaData
+
+	// raw is the whole config decoded into maps. It's used to look up *_file
+	// keys and to compare sections when reloading.
+	raw map[string]interface{}
+
+	// Keep track of what has been decoded so unknown keys can be reported.
+	lock     sync.Mutex
+	decoded  map[string]bool
+	fileKeys map[string]bool
+	reported map[string]bool
+}
+
+func parseConfig(confReader io.Reader) (*configData, error) {
+	data, err := ioutil.ReadAll(confReader)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &configData{
+		values:   make(map[string]toml.Primitive),
+		raw:      make(map[string]interface{}),
+		decoded:  make(map[string]bool),
+		fileKeys: make(map[string]bool),
+		reported: make(map[string]bool),
+	}
+
+	conf.md, err = toml.Decode(string(data), &conf.values)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = toml.Decode(string(data), &conf.raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// checkUnknownKeys reports any keys in decoded sections which weren't used.
+// If strict is set, they are treated as an error.
+func (b *Bot) checkUnknownKeys(conf *configData, strict bool) error {
+	keys := conf.unreportedKeys()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if strict {
+		return fmt.Errorf("Unknown config keys: %s", strings.Join(keys, ", "))
+	}
+
+	for _, key := range keys {
+		b.log.WithField("key", key).Warn("Unknown config key")
+	}
+
+	return nil
+}
+
+// decode decodes the given section into c and then applies any overrides from
+// the environment or secret files.
+func (conf *configData) decode(name string, c interface{}) error {
+	v, ok := conf.values[name]
+	if !ok {
+		return fmt.Errorf("Config section for %q missing", name)
+	}
+
+	conf.lock.Lock()
+	conf.decoded[name] = true
+	err := conf.md.PrimitiveDecode(v, c)
+	conf.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return conf.applyOverrides(name, c)
+}
+
+// unknownKeys returns all keys in decoded sections which didn't match
+// anything they were decoded into.
+func (conf *configData) unknownKeys() []string {
+	conf.lock.Lock()
+	defer conf.lock.Unlock()
+
+	var ret []string
+
+	for _, key := range conf.md.Undecoded() {
+		if len(key) < 2 || !conf.decoded[key[0]] {
+			continue
+		}
+
+		name := key.String()
+		if conf.fileKeys[strings.ToLower(name)] {
+			continue
+		}
+
+		ret = append(ret, name)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// unreportedKeys returns any unknown keys which haven't been returned by
+// this function before, so each one is only logged once.
+func (conf *configData) unreportedKeys() []string {
+	keys := conf.unknownKeys()
+
+	conf.lock.Lock()
+	defer conf.lock.Unlock()
+
+	var ret []string
+
+	for _, key := range keys {
+		if !conf.reported[key] {
+			conf.reported[key] = true
+			ret = append(ret, key)
+		}
+	}
+
+	return ret
+}
+
+// unusedSections returns all sections which were never decoded.
+func (conf *configData) unusedSections() []string {
+	conf.lock.Lock()
+	defer conf.lock.Unlock()
+
+	var ret []string
+
+	for name := range conf.values {
+		if !conf.decoded[name] {
+			ret = append(ret, name)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// applyOverrides replaces values in the config struct c with values from the
+// environment or secret files. For a key named "key" in the section "section",
+// the first of these which is set will be used:
+//
+//  1. The SEABIRD_SECTION_KEY environment variable
+//  2. The contents of the file named by SEABIRD_SECTION_KEY_FILE
+//  3. The contents of the file named by key_file in the config section
+//  4. The value of key in the config section
+func (conf *configData) applyOverrides(section string, c interface{}) error {
+	rv := reflect.ValueOf(c)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	rawSection, _ := conf.raw[section].(map[string]interface{})
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+
+		// Skip unexported fields
+		if field.PkgPath != "" {
+			continue
+		}
+
+		key := configKey(field)
+		if key == "-" {
+			continue
+		}
+
+		// The *_file version of every key is valid, even though it isn't in
+		// the struct.
+		conf.lock.Lock()
+		conf.fileKeys[strings.ToLower(section+"."+key+"_file")] = true
+		conf.lock.Unlock()
+
+		value, ok, err := lookupOverride(section, key, rawSection)
+		if err != nil {
+			return &ConfigError{section, key, err}
+		}
+
+		if !ok {
+			continue
+		}
+
+		err = setConfigValue(rv.Field(i), value)
+		if err != nil {
+			return &ConfigError{section, key, err}
+		}
+	}
+
+	return nil
+}
+
+// configKey returns the name of the config key for a struct field.
+func configKey(field reflect.StructField) string {
+	if tag := strings.Split(field.Tag.Get("toml"), ",")[0]; tag != "" {
+		return tag
+	}
+
+	return field.Name
+}
+
+// configEnvName returns the name of the environment variable which can be used
+// to override a config key.
+func configEnvName(section, key string) string {
+	return "SEABIRD_" + envSafe(section) + "_" + envSafe(key)
+}
+
+func envSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - ('a' - 'A')
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// lookupOverride returns the value which should override the given key, if
+// there is one.
+func lookupOverride(section, key string, rawSection map[string]interface{}) (string, bool, error) {
+	envName := configEnvName(section, key)
+
+	if value, ok := os.LookupEnv(envName); ok {
+		return value, true, nil
+	}
+
+	if filename, ok := os.LookupEnv(envName + "_FILE"); ok {
+		value, err := readSecretFile(filename)
+		return value, true, err
+	}
+
+	// toml keys are matched case insensitively, so we do the same here.
+	for k, v := range rawSection {
+		if !strings.EqualFold(k, key+"_file") {
+			continue
+		}
+
+		filename, ok := v.(string)
+		if !ok {
+			return "", false, fmt.Errorf("%s must be a string", k)
+		}
+
+		value, err := readSecretFile(filename)
+
+		return value, true, err
+	}
+
+	return "", false, nil
+}
+
+// readSecretFile returns the contents of a file without any trailing newline.
+func readSecretFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// setConfigValue parses the given string into the config field.
+func setConfigValue(v reflect.Value, value string) error {
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(value))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+
+		var items []string
+
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package seabird
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ConfigReport is the result of checking a config file with CheckConfig.
+type ConfigReport struct {
+	// Plugins contains every plugin enabled by the config in the order they
+	// are loaded.
+	Plugins []string
+
+	// Loaded contains the plugins which loaded successfully, or which passed
+	// their Validate hook.
+	Loaded []string
+
+	// Failed maps the name of each plugin which failed to load to the error
+	// it returned.
+	Failed map[string]error
+
+	// UnknownKeys contains any config keys which weren't used by the core or
+	// a loaded plugin.
+	UnknownKeys []string
+
+	// UnusedSections contains any config sections which weren't used by the
+	// core or a loaded plugin.
+	UnusedSections []string
+
+	strict bool
+}
+
+// OK returns true if every plugin loaded. If StrictConfig is set, there must
+// also be no unknown keys.
+func (r *ConfigReport) OK() bool {
+	return len(r.Failed) == 0 && (!r.strict || len(r.UnknownKeys) == 0)
+}
+
+func (r *ConfigReport) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("Enabled plugins: %s", strings.Join(r.Plugins, ", ")))
+	lines = append(lines, fmt.Sprintf("Loaded plugins: %s", strings.Join(r.Loaded, ", ")))
+
+	failed := make([]string, 0, len(r.Failed))
+	for name := range r.Failed {
+		failed = append(failed, name)
+	}
+
+	sort.Strings(failed)
+
+	for _, name := range failed {
+		lines = append(lines, fmt.Sprintf("Plugin %q failed: %s", name, r.Failed[name]))
+	}
+
+	for _, key := range r.UnknownKeys {
+		lines = append(lines, fmt.Sprintf("Unknown config key: %s", key))
+	}
+
+	for _, section := range r.UnusedSections {
+		lines = append(lines, fmt.Sprintf("Unused config section: %s", section))
+	}
+
+	if r.OK() {
+		lines = append(lines, "Config OK")
+	} else {
+		lines = append(lines, "Config has errors")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// CheckConfig validates a config file without connecting to IRC. The core
+// config is decoded and validated, then every enabled plugin is checked by a
+// bot which isn't connected so any problems with their config are found.
+// Plugins with a Validate hook in their PluginInfo only have that called.
+// Every other plugin is loaded with its PluginFactory.
+//
+// An error is returned rather than a report if the config can't be parsed,
+// the core config is invalid, there are unknown core keys and StrictConfig is
+// set, or the enabled plugins can't be ordered because of a dependency cycle
+// or a required dependency which isn't enabled. Failures while loading
+// plugins are included in the report instead.
+//
+// Note that plugins without a Validate hook are really loaded, so they may
+// have side effects like connecting to a database. This isn't safe to run
+// against a config which points at production resources unless every enabled
+// plugin has a Validate hook. All plugins are unloaded before returning.
+func CheckConfig(confReader io.Reader) (*ConfigReport, error) {
+	b, err := NewBot(confReader)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.checkConfig()
+}
+
+// checkConfig is CheckConfig for a bot which has already been created.
+func (b *Bot) checkConfig() (*ConfigReport, error) {
+	b.checkOnly = true
+
+	pluginNames, err := b.config.enabledPlugins(b.plugins)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ConfigReport{
+		Plugins: pluginNames,
+		Failed:  make(map[string]error),
+		strict:  b.config.StrictConfig,
+	}
+
+	for _, name := range pluginNames {
+		b.loadedPlugins[name] = false
+	}
+
+	// Unlike a normal startup, we keep going after a failure so every
+	// problem is reported at once.
+	for _, name := range pluginNames {
+		_ = b.EnsurePlugin(name)
+	}
+
+	for name, err := range b.failedPlugins {
+		report.Failed[name] = err
+	}
+
+	report.Loaded = b.LoadedPlugins()
+	report.UnknownKeys = b.conf.unknownKeys()
+	report.UnusedSections = b.conf.unusedSections()
+
+	for _, name := range report.Loaded {
+		b.teardownPlugin(name)
+	}
+
+	return report, nil
+}
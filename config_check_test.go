@@ -0,0 +1,99 @@
+package seabird_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seabird "github.com/belak/go-seabird"
+)
+
+func TestCheckConfig(t *testing.T) {
+	var (
+		values    []string
+		teardowns int
+	)
+
+	report, err := checkTestConfig(`
+plugins = ["test/reload", "test/reload-dep", "test/reload-config"]
+tlsnoverfy = true
+
+[unused]
+value = 1
+`, reloadPlugin(&teardowns), reloadDepPlugin(), reloadConfigPlugin(&values))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"test/reload", "test/reload-config", "test/reload-dep"}, report.Plugins)
+	assert.Equal(t, []string{"test/reload", "test/reload-dep"}, report.Loaded)
+	assert.Len(t, report.Failed, 1)
+	assert.EqualError(t, report.Failed["test/reload-config"],
+		`Plugin test/reload-config failed to load: Config section for "reload_config" missing`)
+	assert.Equal(t, []string{"core.tlsnoverfy"}, report.UnknownKeys)
+	assert.Equal(t, []string{"unused"}, report.UnusedSections)
+	assert.False(t, report.OK())
+	assert.Contains(t, report.String(), `Plugin "test/reload-config" failed`)
+
+	// Everything should be unloaded after the check.
+	assert.Equal(t, 1, teardowns)
+}
+
+// checkValidatePlugin counts how many times its PluginFactory was called.
+func checkValidatePlugin(loads *int) testPlugin {
+	return testPlugin{
+		name: "test/check-validate",
+		factory: func(b *seabird.Bot) error {
+			*loads++
+			return nil
+		},
+		info: seabird.PluginInfo{
+			Validate: func(b *seabird.Bot) error {
+				c := &struct{ Value string }{}
+				if err := b.Config("check_validate", c); err != nil {
+					return err
+				}
+
+				if c.Value == "" {
+					return errors.New("value is required")
+				}
+
+				return nil
+			},
+		},
+	}
+}
+
+func TestCheckConfigValidate(t *testing.T) {
+	var loads int
+
+	config := `
+plugins = ["test/check-validate"]
+
+[check_validate]
+`
+
+	report, err := checkTestConfig(config+`value = "a"`, checkValidatePlugin(&loads))
+	require.NoError(t, err)
+
+	assert.True(t, report.OK())
+	assert.Equal(t, []string{"test/check-validate"}, report.Loaded)
+	assert.Empty(t, report.UnusedSections)
+
+	report, err = checkTestConfig(config, checkValidatePlugin(&loads))
+	require.NoError(t, err)
+
+	assert.False(t, report.OK())
+	assert.EqualError(t, report.Failed["test/check-validate"],
+		"Plugin test/check-validate failed to load: value is required")
+
+	// The PluginFactory should never be called by the check.
+	assert.Equal(t, 0, loads)
+}
+
+func TestCheckConfigInvalidCore(t *testing.T) {
+	_, err := checkTestConfig(`
+host = "nope"
+`)
+	assert.EqualError(t, err, "core.host: must be in the form host:port")
+}
@@ -0,0 +1,260 @@
+package seabird
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+type configReloadHook struct {
+	section string
+	f       func() error
+}
+
+// OnConfigReload registers a function which will be called when the config is
+// reloaded and the given section has changed. The function should use Config
+// to decode the new values. This should be called from a PluginFactory. Any
+// error returned will be logged and reported by ReloadConfig.
+func (b *Bot) OnConfigReload(section string, f func() error) {
+	state := b.currentPluginState()
+	if state == nil {
+		b.log.Warn("OnConfigReload called outside of a PluginFactory")
+		return
+	}
+
+	state.reload = append(state.reload, configReloadHook{section, f})
+}
+
+// ReloadConfigFile re-reads the config file the bot was created with in
+// NewBotFromFile and applies it with ReloadConfig.
+func (b *Bot) ReloadConfigFile() error {
+	if b.confPath == "" {
+		return errors.New("Bot was not loaded from a config file")
+	}
+
+	f, err := os.Open(b.confPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return b.ReloadConfig(f)
+}
+
+// ReloadConfig replaces the bot's config with a new one. If the new config is
+// invalid, the old one is kept. Otherwise the prefix, log level, send limits
+// and plugin list are applied immediately, plugins which were removed from
+// the config are unloaded, new plugins are loaded and any plugins with
+// changed config sections are notified with the callbacks they registered with
+// OnConfigReload. Other changes to the core config require a restart.
+func (b *Bot) ReloadConfig(confReader io.Reader) error {
+	conf, config, err := decodeConfig(confReader)
+	if err != nil {
+		return err
+	}
+
+	pluginNames, err := config.enabledPlugins(b.plugins)
+	if err != nil {
+		return err
+	}
+
+	err = b.checkUnknownKeys(conf, config.StrictConfig)
+	if err != nil {
+		return err
+	}
+
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	b.configLock.Lock()
+	oldConf := b.conf
+	b.conf = conf
+	b.configLock.Unlock()
+
+	b.applyCoreConfig(config)
+
+	previouslyLoaded := b.loadedPluginNames()
+
+	b.log.Infof("Enabled plugins: %s", strings.Join(pluginNames, ", "))
+
+	errs := b.applyPluginList(pluginNames)
+
+	// Plugins which were just loaded have already seen the new config, so only
+	// the ones which were already running need to be notified.
+	for _, name := range previouslyLoaded {
+		if !b.loadedPlugins[name] {
+			continue
+		}
+
+		b.pluginLock.Lock()
+		state := b.pluginStates[name]
+		b.pluginLock.Unlock()
+
+		if state == nil {
+			continue
+		}
+
+		for _, hook := range state.reload {
+			if !sectionChanged(oldConf, conf, hook.section) {
+				continue
+			}
+
+			err = b.callPluginHook(name, hook.f)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("Plugin %q failed to reload %q: %s", name, hook.section, err))
+			}
+		}
+	}
+
+	// Plugin sections are only decoded if the plugin was loaded or notified,
+	// so these can only be reported after the fact.
+	if err = b.checkUnknownKeys(conf, config.StrictConfig); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			b.log.Error(e)
+		}
+
+		return fmt.Errorf("Config reloaded with errors: %s", strings.Join(errs, "; "))
+	}
+
+	b.log.Info("Config reloaded")
+
+	return nil
+}
+
+// applyCoreConfig applies the core config values which can be changed while
+// the bot is running.
+func (b *Bot) applyCoreConfig(config coreConfig) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	b.configureCommandMux(config)
+	b.log.Logger.SetLevel(config.logLevel())
+	b.queue.setLimit(config.SendLimit.Duration, config.SendBurst)
+	b.workers.setTimeout(config.HandlerTimeout.Duration)
+
+	live := b.config
+	live.Prefix, live.ChannelPrefixes = config.Prefix, config.ChannelPrefixes
+	live.MentionPrefix, live.SuggestCommands = config.MentionPrefix, config.SuggestCommands
+	live.LogLevel, live.Debug = config.LogLevel, config.Debug
+	live.SendLimit, live.SendBurst = config.SendLimit, config.SendBurst
+	live.Plugins, live.DisabledPlugins = config.Plugins, config.DisabledPlugins
+	live.Admins, live.Roles, live.roles = config.Admins, config.Roles, config.roles
+	live.RateLimits, live.HandlerTimeout = config.RateLimits, config.HandlerTimeout
+
+	if !reflect.DeepEqual(live, config) {
+		b.log.Warn("Some core config changes will not be applied until the bot is restarted")
+	}
+
+	b.config = live
+}
+
+// applyPluginList unloads any loaded plugins which are no longer enabled and
+// loads any newly enabled plugins. The plugin names must already be sorted
+// with enabledPlugins. It returns a description of everything
+// which failed.
+func (b *Bot) applyPluginList(pluginNames []string) []string {
+	var errs []string
+
+	enabled := make(map[string]bool)
+
+	for _, name := range pluginNames {
+		enabled[name] = true
+
+		if _, ok := b.loadedPlugins[name]; !ok {
+			b.loadedPlugins[name] = false
+		}
+	}
+
+	// Plugins can only be unloaded once nothing depends on them, so keep
+	// going until no more can be unloaded.
+	for progress := true; progress; {
+		progress = false
+
+		for _, name := range b.loadedPluginNames() {
+			if enabled[name] || len(b.pluginDependents(name)) > 0 {
+				continue
+			}
+
+			b.teardownPlugin(name)
+			delete(b.loadedPlugins, name)
+
+			progress = true
+		}
+	}
+
+	for name, loaded := range b.loadedPlugins {
+		if !loaded && !enabled[name] {
+			delete(b.loadedPlugins, name)
+			delete(b.failedPlugins, name)
+		}
+	}
+
+	for _, name := range b.loadedPluginNames() {
+		if !enabled[name] {
+			errs = append(errs, fmt.Sprintf(
+				"Plugin %q is still required by: %s",
+				name, strings.Join(b.pluginDependents(name), ", ")))
+		}
+	}
+
+	for _, name := range pluginNames {
+		if b.loadedPlugins[name] {
+			continue
+		}
+
+		err := b.enablePlugin(name)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	return errs
+}
+
+// sectionChanged returns true if the given config section differs between
+// the two configs.
+func sectionChanged(oldConf, newConf *configData, name string) bool {
+	return !reflect.DeepEqual(oldConf.raw[name], newConf.raw[name])
+}
+
+// watchReloadSignal reloads the config file whenever the process receives a
+// SIGHUP. The returned function stops watching.
+func (b *Bot) watchReloadSignal() func() {
+	if b.confPath == "" {
+		return func() {}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				b.log.Info("Received SIGHUP, reloading config")
+
+				if err := b.ReloadConfigFile(); err != nil {
+					b.log.WithError(err).Error("Failed to reload config")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
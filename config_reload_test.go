@@ -0,0 +1,145 @@
+package seabird_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seabird "github.com/belak/go-seabird"
+)
+
+type reloadConfig struct {
+	Value string
+}
+
+// reloadConfigPlugin records the value from its config section every time it
+// is loaded or its section changes.
+func reloadConfigPlugin(values *[]string) testPlugin {
+	return plugin("test/reload-config", func(b *seabird.Bot) error {
+		load := func() error {
+			c := &reloadConfig{}
+			if err := b.Config("reload_config", c); err != nil {
+				return err
+			}
+
+			*values = append(*values, c.Value)
+
+			return nil
+		}
+
+		b.OnConfigReload("reload_config", load)
+
+		return load()
+	})
+}
+
+func reloadTestConfig(prefix, plugins, value string) string {
+	return `
+prefix = "` + prefix + `"
+plugins = [` + plugins + `]
+
+[reload_config]
+value = "` + value + `"
+`
+}
+
+func TestReloadConfig(t *testing.T) {
+	var (
+		values    []string
+		teardowns int
+	)
+
+	b := newTestBot(t, reloadTestConfig("!", `"test/reload", "test/reload-config"`, "a"),
+		reloadPlugin(&teardowns), reloadConfigPlugin(&values))
+
+	runTestBot(t, b)
+
+	assert.Equal(t, []string{"a"}, values)
+
+	// An invalid config shouldn't change anything.
+	err := b.ReloadConfig(strings.NewReader(`
+[core]
+prefix = "?"
+loglevel = "not-a-level"
+`))
+	assert.Error(t, err)
+	assert.Equal(t, "!", b.CommandMux().Prefix())
+
+	err = b.ReloadConfig(strings.NewReader(testConfig(
+		reloadTestConfig("?", `"test/reload-config"`, "b"))))
+	require.NoError(t, err)
+
+	assert.Equal(t, "?", b.CommandMux().Prefix())
+	assert.Equal(t, []string{"a", "b"}, values)
+	assert.Equal(t, 1, teardowns)
+	assert.Equal(t, []string{"test/reload-config"}, b.LoadedPlugins())
+
+	// Plugins shouldn't be notified if their section didn't change.
+	err = b.ReloadConfig(strings.NewReader(testConfig(
+		reloadTestConfig("?", `"test/reload", "test/reload-config"`, "b"))))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, values)
+	assert.Equal(t, []string{"test/reload", "test/reload-config"}, b.LoadedPlugins())
+}
+
+func TestReloadConfigWhileRunning(t *testing.T) {
+	var teardowns int
+
+	config := func(prefix string, maxLines int) string {
+		return fmt.Sprintf(`
+prefix = %q
+plugins = ["test/reload"]
+cmds = ["JOIN #chan"]
+maxreplylines = %d
+panicreply = "oops"
+admins = ["belak!*@*"]
+admincommands = true
+`, prefix, maxLines)
+	}
+
+	b := newTestBot(t, config("!", 1), reloadPlugin(&teardowns))
+
+	lines := []string{"001 bot :Welcome"}
+	for i := 0; i < 200; i++ {
+		lines = append(lines,
+			":belak!~belak@host PRIVMSG #chan :!ping",
+			":belak!~belak@host PRIVMSG #chan :?plugin list",
+			"001 bot :Welcome",
+		)
+	}
+
+	done := make(chan struct{})
+	reloaded := make(chan struct{})
+
+	// Keep reloading the config while messages are dispatched so the race
+	// detector can catch any unsynchronized access.
+	go func() {
+		defer close(reloaded)
+
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			prefix := "!"
+			if i%2 == 0 {
+				prefix = "?"
+			}
+
+			assert.NoError(t, b.ReloadConfig(strings.NewReader(testConfig(config(prefix, i%3)))))
+		}
+	}()
+
+	runTestBot(t, b, lines...)
+
+	close(done)
+	<-reloaded
+
+	assert.Equal(t, []string{"test/reload"}, b.LoadedPlugins())
+}
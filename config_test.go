@@ -0,0 +1,187 @@
+package seabird_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seabird "github.com/belak/go-seabird"
+	"github.com/belak/go-seabird/internal"
+)
+
+type overrideConfig struct {
+	APIKey  string `toml:"api_key"`
+	Secret  string
+	Count   int
+	Enabled bool
+	Timeout internal.Duration
+	Names   []string
+}
+
+func writeSecretFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "seabird-secret")
+	require.NoError(t, err)
+
+	_, err = f.WriteString(contents)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	return f.Name()
+}
+
+func setEnv(t *testing.T, key, value string) {
+	require.NoError(t, os.Setenv(key, value))
+}
+
+func TestConfigOverrides(t *testing.T) {
+	secretFile := writeSecretFile(t, "from-file\n")
+	defer os.Remove(secretFile)
+
+	envSecretFile := writeSecretFile(t, "from-env-file")
+	defer os.Remove(envSecretFile)
+
+	setEnv(t, "SEABIRD_MY_PLUGIN_API_KEY", "from-env")
+	setEnv(t, "SEABIRD_MY_PLUGIN_API_KEY_FILE", envSecretFile)
+	setEnv(t, "SEABIRD_MY_PLUGIN_COUNT", "42")
+	setEnv(t, "SEABIRD_MY_PLUGIN_ENABLED", "true")
+	setEnv(t, "SEABIRD_MY_PLUGIN_TIMEOUT", "5s")
+	setEnv(t, "SEABIRD_MY_PLUGIN_NAMES", "a, b,c")
+
+	defer func() {
+		for _, key := range []string{"API_KEY", "API_KEY_FILE", "COUNT", "ENABLED", "TIMEOUT", "NAMES"} {
+			os.Unsetenv("SEABIRD_MY_PLUGIN_" + key)
+		}
+	}()
+
+	b := newTestBot(t, `
+[my-plugin]
+api_key = "from-toml"
+secret = "from-toml"
+secret_file = "`+secretFile+`"
+count = 1
+`)
+
+	c := &overrideConfig{}
+	require.NoError(t, b.Config("my-plugin", c))
+
+	assert.Equal(t, &overrideConfig{
+		APIKey:  "from-env",
+		Secret:  "from-file",
+		Count:   42,
+		Enabled: true,
+		Timeout: internal.Duration{Duration: 5 * time.Second},
+		Names:   []string{"a", "b", "c"},
+	}, c)
+
+	// The _FILE variable should be used if the plain one isn't set.
+	os.Unsetenv("SEABIRD_MY_PLUGIN_API_KEY")
+	require.NoError(t, b.Config("my-plugin", c))
+	assert.Equal(t, "from-env-file", c.APIKey)
+
+	// Invalid values should be reported with the key they came from.
+	setEnv(t, "SEABIRD_MY_PLUGIN_COUNT", "many")
+	err := b.Config("my-plugin", c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "my-plugin.Count")
+}
+
+func TestCoreConfigOverrides(t *testing.T) {
+	setEnv(t, "SEABIRD_CORE_PASS", "hunter2")
+	defer os.Unsetenv("SEABIRD_CORE_PASS")
+
+	b := newTestBot(t, `
+pass = "not-the-password"
+`)
+
+	sent := runTestBot(t, b)
+
+	require.NotEmpty(t, sent)
+	assert.Equal(t, normalizeLines("PASS hunter2"), sentLines(sent[:1]))
+}
+
+func TestConfigValidation(t *testing.T) {
+	var tests = []struct {
+		config string
+		err    string
+	}{
+		{`prefix = ""`, "core.prefix: must not be empty"},
+		{`host = "irc.example.com"`, "core.host: must be in the form host:port"},
+		{`host = "irc.example.com:ircs"`, `core.host: invalid port "ircs"`},
+		{`tlscert = "cert.pem"`, "core.tlskey: required when tlscert is set"},
+		{`tlskey = "key.pem"`, "core.tlscert: required when tlskey is set"},
+		{`loglevel = "loud"`, "core.loglevel"},
+		{`channelprefixes = { "#chan" = ["~", ""] }`, "core.channelprefixes: prefixes for #chan must not be empty"},
+		{"[core.roles.empty]\npermissions = [\"a\"]", "core.roles.empty: must match by accounts, hostmasks or channelops"},
+		{"[core.roles.bad]\nhostmasks = [\"*!*@host\"]\npermissions = [\"[a\"]", "core.roles.bad.permissions"},
+		{"[core.ratelimits.weather]\nscope = \"network\"\ninterval = \"1m\"", `core.ratelimits.weather.scope: unknown scope "network"`},
+		{"[core.ratelimits.weather]\nburst = 2", "core.ratelimits.weather.interval: must be positive"},
+		{`workers = -1`, "core.workers: must not be negative"},
+		{`handlertimeout = "-1s"`, "core.handlertimeout: must not be negative"},
+		{`sendburst = -1`, "core.sendburst: must not be negative"},
+		{`reconnectjitter = 2.0`, "core.reconnectjitter: must be between 0 and 1"},
+		{`saslmechanism = "SCRAM-SHA-256"`, "core.saslmechanism: unsupported SASL mechanism"},
+		{"strictconfig = true\ntlsnoverfy = true", "Unknown config keys: core.tlsnoverfy"},
+		{`host = "irc.example.com:6697"`, ""},
+		{`pass_file = "/dev/null"`, ""},
+	}
+
+	for _, test := range tests {
+		_, err := seabird.NewBotWithPlugins(strings.NewReader(testConfig(test.config)), seabird.NewPluginRegistry())
+
+		if test.err == "" {
+			assert.NoError(t, err, test.config)
+			continue
+		}
+
+		if assert.Error(t, err, test.config) {
+			assert.Contains(t, err.Error(), test.err, test.config)
+		}
+	}
+}
+
+func TestConfigRequired(t *testing.T) {
+	_, err := seabird.NewBot(strings.NewReader(`
+[core]
+user = "bot"
+name = "bot"
+`))
+	require.Error(t, err)
+	assert.Equal(t, "core.nick: required", err.Error())
+
+	var configErr *seabird.ConfigError
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, "core", configErr.Section)
+	assert.Equal(t, "nick", configErr.Key)
+}
+
+func TestStrictConfigPluginKeys(t *testing.T) {
+	var values []string
+
+	b := newTestBot(t, `
+strictconfig = true
+
+[reload_config]
+value = "a"
+valeu = "b"
+`, reloadConfigPlugin(&values))
+
+	_, err := runTestBotErr(t, b)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reload_config.valeu")
+}
+
+func TestSampleConfig(t *testing.T) {
+	f, err := os.Open("_extra/config.sample.toml")
+	require.NoError(t, err)
+
+	defer f.Close()
+
+	_, err = seabird.NewBot(f)
+	assert.NoError(t, err)
+}
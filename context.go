@@ -15,6 +15,8 @@ const (
 
 	contextKeyCurrentNick = internal.ContextKey("seabird-current-nick")
 	contextKeyRequestID   = internal.ContextKey("seabird-request-id")
+	contextKeyCaps        = internal.ContextKey("seabird-caps")
+	contextKeyISupport    = internal.ContextKey("seabird-isupport")
 )
 
 func withSeabirdValues(ctx context.Context, b *Bot, log *logrus.Entry) context.Context {
@@ -29,7 +31,10 @@ func CtxBot(ctx context.Context) *Bot {
 }
 
 func CtxLogger(ctx context.Context, name string) *logrus.Entry {
-	logger := ctx.Value(contextKeyLogger).(*logrus.Entry)
+	logger, ok := ctx.Value(contextKeyLogger).(*logrus.Entry)
+	if !ok {
+		logger = logrus.NewEntry(logrus.StandardLogger())
+	}
 
 	return logger.WithField("category", name)
 }
@@ -41,3 +46,21 @@ func CtxCurrentNick(ctx context.Context) string {
 func CtxRequestID(ctx context.Context) uuid.UUID {
 	return ctx.Value(contextKeyRequestID).(uuid.UUID)
 }
+
+// CtxCapEnabled returns true if the given IRCv3 cap was enabled on the
+// connection at the time the request was received.
+func CtxCapEnabled(ctx context.Context, name string) bool {
+	caps, _ := ctx.Value(contextKeyCaps).(map[string]bool)
+
+	return caps[name]
+}
+
+// CtxISupport returns the RPL_ISUPPORT values the server sent. If the context
+// didn't come from a connected Bot, the defaults will be returned.
+func CtxISupport(ctx context.Context) *ISupport {
+	if isupport, ok := ctx.Value(contextKeyISupport).(*ISupport); ok {
+		return isupport
+	}
+
+	return DefaultISupport()
+}
@@ -0,0 +1,33 @@
+package seabird
+
+import "io"
+
+// These let the external tests give each bot its own plugins rather than
+// sharing the ones registered with RegisterPlugin.
+
+// NewPluginRegistry returns an empty plugin registry.
+func NewPluginRegistry() *pluginRegistry {
+	return newPluginRegistry()
+}
+
+// Register adds a plugin to the registry.
+func (r *pluginRegistry) Register(name string, factory PluginFactory, info PluginInfo) {
+	r.register(name, factory, info)
+}
+
+// NewBotWithPlugins is NewBot, but the bot can only load plugins from the
+// given registry.
+func NewBotWithPlugins(confReader io.Reader, plugins *pluginRegistry) (*Bot, error) {
+	return newBot(confReader, plugins)
+}
+
+// CheckConfigWithPlugins is CheckConfig, but only plugins from the given
+// registry are checked.
+func CheckConfigWithPlugins(confReader io.Reader, plugins *pluginRegistry) (*ConfigReport, error) {
+	b, err := newBot(confReader, plugins)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.checkConfig()
+}
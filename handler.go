@@ -1,5 +1,11 @@
 package seabird
 
+import (
+	"reflect"
+	"runtime"
+	"runtime/debug"
+)
+
 // Handler is an interface representing objects which can be registered to serve
 // a particular Event.Command or subcommand in the IRC client.
 type Handler interface {
@@ -9,9 +15,8 @@ type Handler interface {
 	// Event.
 	//
 	// Note that if there are calls that may block for a long time such as
-	// network requests and IO, it may be best to grab the required data and run
-	// the response code in a goroutine so the rest of the Client can continue
-	// as usual.
+	// network requests and IO, the handler should be registered with the Async
+	// middleware so the rest of the Client can continue as usual.
 	HandleEvent(r *Request)
 }
 
@@ -24,3 +29,46 @@ type HandlerFunc func(r *Request)
 func (f HandlerFunc) HandleEvent(r *Request) {
 	f(r)
 }
+
+// callHandler runs a handler, recovering from any panics so a single broken
+// handler can't take down the whole bot. Any panic will be attributed to h,
+// but run is what will actually be called so middleware can be included. If
+// replyOnPanic is true, the user will be sent the configured PanicReply.
+func callHandler(r *Request, h, run HandlerFunc, replyOnPanic bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			handlePanic(r, h, err, replyOnPanic)
+		}
+	}()
+
+	run(r)
+}
+
+func handlePanic(r *Request, h HandlerFunc, err interface{}, replyOnPanic bool) {
+	name := handlerName(h)
+
+	r.GetLogger("mux").
+		WithField("handler", name).
+		WithField("panic", err).
+		Errorf("Recovered from panic in handler: %s", debug.Stack())
+
+	if r.bot == nil {
+		return
+	}
+
+	r.bot.recordPanic(name)
+
+	if reply := r.bot.currentConfig().PanicReply; replyOnPanic && reply != "" {
+		_ = r.MentionReplyf("%s", reply)
+	}
+}
+
+// handlerName returns the name of the function backing the given handler.
+func handlerName(h HandlerFunc) string {
+	f := runtime.FuncForPC(reflect.ValueOf(h).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+
+	return f.Name()
+}
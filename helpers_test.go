@@ -0,0 +1,181 @@
+package seabird_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	irc "gopkg.in/irc.v3"
+
+	seabird "github.com/belak/go-seabird"
+	utils "github.com/belak/go-seabird/test-utils"
+)
+
+// testPlugin is a plugin which is only available to the bot in a single test.
+type testPlugin struct {
+	name    string
+	factory seabird.PluginFactory
+	info    seabird.PluginInfo
+}
+
+// plugin is a testPlugin without any metadata.
+func plugin(name string, factory seabird.PluginFactory) testPlugin {
+	return testPlugin{name: name, factory: factory}
+}
+
+// testConfig returns a config with the required core options. The extra
+// config is added to the end of the [core] section, so it can set other core
+// options and then start more sections.
+func testConfig(extra string) string {
+	return `
+[core]
+nick = "bot"
+user = "bot"
+name = "bot"
+` + extra
+}
+
+// newTestBot creates a bot which can only load the given plugins. Unless the
+// config sets plugins, all of them are loaded.
+func newTestBot(t *testing.T, config string, plugins ...testPlugin) *seabird.Bot {
+	t.Helper()
+
+	registry := seabird.NewPluginRegistry()
+	for _, p := range plugins {
+		registry.Register(p.name, p.factory, p.info)
+	}
+
+	b, err := seabird.NewBotWithPlugins(strings.NewReader(testConfig(config)), registry)
+	require.NoError(t, err)
+
+	return b
+}
+
+// checkTestConfig runs CheckConfig with only the given plugins registered.
+func checkTestConfig(config string, plugins ...testPlugin) (*seabird.ConfigReport, error) {
+	registry := seabird.NewPluginRegistry()
+	for _, p := range plugins {
+		registry.Register(p.name, p.factory, p.info)
+	}
+
+	return seabird.CheckConfigWithPlugins(strings.NewReader(testConfig(config)), registry)
+}
+
+// runTestBot connects the bot to a fake server which sends the given lines
+// and returns every message the bot sent once the server runs out of lines.
+func runTestBot(t *testing.T, b *seabird.Bot, lines ...string) []*irc.Message {
+	t.Helper()
+
+	// The connection always ends when the server has nothing left to send.
+	sent, err := runTestBotErr(t, b, lines...)
+	require.True(t, errors.Is(err, io.EOF), "unexpected error: %v", err)
+
+	return sent
+}
+
+// runTestBotErr is runTestBot for when the bot should stop with an error.
+func runTestBotErr(t *testing.T, b *seabird.Bot, lines ...string) ([]*irc.Message, error) {
+	t.Helper()
+
+	testCS := utils.NewTestClientServer()
+	testCS.SendServerLines(lines)
+
+	err := b.Run(testCS)
+
+	return parseSent(t, testCS.ClientString()), err
+}
+
+// parseSent parses everything the bot sent.
+func parseSent(t *testing.T, out string) []*irc.Message {
+	t.Helper()
+
+	var ret []*irc.Message
+
+	for _, line := range strings.Split(out, "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		m, err := irc.ParseMessage(line)
+		require.NoError(t, err)
+
+		ret = append(ret, m)
+	}
+
+	return ret
+}
+
+// sentLines converts messages back to lines so differences are easy to read.
+// Lines are always written the same way, so a line which was parsed and
+// written again can be compared exactly.
+func sentLines(sent []*irc.Message) []string {
+	ret := make([]string, 0, len(sent))
+	for _, m := range sent {
+		ret = append(ret, m.String())
+	}
+
+	return ret
+}
+
+// normalizeLines parses and writes each line again so they can be compared
+// with sentLines.
+func normalizeLines(lines ...string) []string {
+	ret := make([]string, 0, len(lines))
+	for _, line := range lines {
+		ret = append(ret, irc.MustParseMessage(line).String())
+	}
+
+	return ret
+}
+
+// filterSent returns only the messages with the given command.
+func filterSent(sent []*irc.Message, command string) []*irc.Message {
+	var ret []*irc.Message
+
+	for _, m := range sent {
+		if m.Command == command {
+			ret = append(ret, m)
+		}
+	}
+
+	return ret
+}
+
+// assertSent checks the bot sent every one of the given lines.
+func assertSent(t *testing.T, sent []*irc.Message, lines ...string) {
+	t.Helper()
+
+	actual := sentLines(sent)
+	for _, line := range normalizeLines(lines...) {
+		assert.Contains(t, actual, line)
+	}
+}
+
+// assertNotSent checks the bot didn't send any of the given lines.
+func assertNotSent(t *testing.T, sent []*irc.Message, lines ...string) {
+	t.Helper()
+
+	actual := sentLines(sent)
+	for _, line := range normalizeLines(lines...) {
+		assert.NotContains(t, actual, line)
+	}
+}
+
+// assertSentExactly checks the messages the bot sent with the given command
+// are exactly the given lines, in order.
+func assertSentExactly(t *testing.T, sent []*irc.Message, command string, lines ...string) {
+	t.Helper()
+
+	assert.Equal(t, normalizeLines(lines...), sentLines(filterSent(sent, command)))
+}
+
+// assertSentUnordered is assertSentExactly for messages which may be handled
+// concurrently, such as ones from different channels.
+func assertSentUnordered(t *testing.T, sent []*irc.Message, command string, lines ...string) {
+	t.Helper()
+
+	assert.ElementsMatch(t, normalizeLines(lines...), sentLines(filterSent(sent, command)))
+}
@@ -0,0 +1,43 @@
+package internal
+
+// Levenshtein returns the edit distance between two strings, counting
+// insertions, deletions and substitutions of runes.
+func Levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	ret := first
+
+	for _, v := range rest {
+		if v < ret {
+			ret = v
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,17 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, Levenshtein("", ""))
+	assert.Equal(t, 0, Levenshtein("weather", "weather"))
+	assert.Equal(t, 7, Levenshtein("", "weather"))
+	assert.Equal(t, 1, Levenshtein("wether", "weather"))
+	assert.Equal(t, 2, Levenshtein("waether", "weather"))
+	assert.Equal(t, 3, Levenshtein("kitten", "sitting"))
+	assert.Equal(t, 1, Levenshtein("é", "e"))
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// SplitMessage splits a message into chunks which are at most maxLen bytes
+// long. It will try to split on spaces, but if a single word is too long it
+// will be split on a UTF-8 rune boundary. Newlines are not treated specially.
+// Chunks which would only contain spaces are dropped, so an empty message
+// results in no chunks at all.
+func SplitMessage(msg string, maxLen int) []string {
+	if maxLen < utf8.UTFMax {
+		maxLen = utf8.UTFMax
+	}
+
+	var ret []string
+
+	for len(msg) > maxLen {
+		// Find the last space we can split on. If there isn't one, we need to
+		// split in the middle of a word.
+		idx := strings.LastIndexByte(msg[:maxLen+1], ' ')
+		if idx <= 0 {
+			idx = maxLen
+			for idx > 0 && !utf8.RuneStart(msg[idx]) {
+				idx--
+			}
+
+			ret = appendChunk(ret, msg[:idx])
+			msg = msg[idx:]
+
+			continue
+		}
+
+		ret = appendChunk(ret, strings.TrimRight(msg[:idx], " "))
+		msg = strings.TrimLeft(msg[idx:], " ")
+	}
+
+	return appendChunk(ret, msg)
+}
+
+// appendChunk adds a chunk to the slice unless it only contains spaces, since
+// there's no point in sending an empty line.
+func appendChunk(chunks []string, chunk string) []string {
+	if strings.Trim(chunk, " ") == "" {
+		return chunks
+	}
+
+	return append(chunks, chunk)
+}
+
+// TruncateString cuts a string down to at most maxLen bytes without splitting
+// a UTF-8 rune.
+func TruncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+
+	return s[:maxLen]
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitMessage(t *testing.T) {
+	// Short messages should be left alone
+	assert.Equal(t, []string{"hello world"}, SplitMessage("hello world", 20))
+	assert.Empty(t, SplitMessage("", 20))
+	assert.Empty(t, SplitMessage("   ", 20))
+
+	// Split on word boundaries when possible
+	assert.Equal(t, []string{"hello", "world"}, SplitMessage("hello world", 8))
+	assert.Equal(t, []string{"hello", "world"}, SplitMessage("hello world", 5))
+	assert.Equal(t, []string{"aaa bbb", "ccc"}, SplitMessage("aaa bbb ccc", 7))
+	assert.Equal(t, []string{"aaa", "bbb"}, SplitMessage("aaa    bbb", 5))
+
+	// Leading, trailing or repeated spaces shouldn't result in empty chunks
+	assert.Equal(t, []string{"aaa"}, SplitMessage("        aaa", 5))
+	assert.Equal(t, []string{"aaa"}, SplitMessage("aaa        ", 5))
+	assert.Equal(t, []string{"aaa", "bbb"}, SplitMessage("aaa            bbb", 5))
+	for _, chunk := range SplitMessage(strings.Repeat(" ", 7)+"a"+strings.Repeat(" ", 20)+"b", 5) {
+		assert.NotEmpty(t, strings.TrimSpace(chunk))
+	}
+
+	// Long words need to be split
+	assert.Equal(t, []string{"aaaaa", "aaa"}, SplitMessage("aaaaaaaa", 5))
+
+	// Never split in the middle of a rune
+	for _, chunk := range SplitMessage(strings.Repeat("é", 10), 5) {
+		assert.True(t, len(chunk) <= 5)
+		assert.Equal(t, "éé", chunk)
+	}
+
+	assert.Equal(t, []string{"aé", "é"}, SplitMessage("aéé", 4))
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "hello", TruncateString("hello", 10))
+	assert.Equal(t, "hel", TruncateString("hello", 3))
+	assert.Equal(t, "é", TruncateString("éé", 3))
+}
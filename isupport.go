@@ -0,0 +1,231 @@
+package seabird
+
+import (
+	"strconv"
+	"strings"
+
+	irc "gopkg.in/irc.v3"
+)
+
+// ISupport contains the values the server sent in RPL_ISUPPORT (005). If the
+// server didn't send a value, the defaults from RFC 1459 will be used.
+type ISupport struct {
+	// ChanTypes contains all the characters a channel name can start with.
+	ChanTypes string
+
+	// PrefixModes and PrefixSymbols map channel membership modes (like o) to
+	// the symbols used for them in NAMES (like @). They are ordered from
+	// highest to lowest.
+	PrefixModes   string
+	PrefixSymbols string
+
+	// ChanModes contains the channel modes for each of the 4 CHANMODES types.
+	ChanModes [4]string
+
+	// CaseMapping is the name of the casemapping used to compare nicks and
+	// channels. It will be one of ascii, rfc1459 or rfc1459-strict.
+	CaseMapping string
+
+	NickLen int
+
+	// Modes is the maximum number of modes with a parameter which can be
+	// sent in a single MODE command. A value of 0 means there is no limit.
+	Modes int
+
+	// TargMax maps a command to the maximum number of targets it accepts. A
+	// value of 0 means there is no limit.
+	TargMax map[string]int
+
+	LineLen int
+	Network string
+
+	// Raw contains every token the server sent, even ones which aren't
+	// parsed into the fields above.
+	Raw map[string]string
+}
+
+// DefaultISupport returns the values which should be used before the server
+// has sent RPL_ISUPPORT.
+func DefaultISupport() *ISupport {
+	return &ISupport{
+		ChanTypes:     "#&",
+		PrefixModes:   "ov",
+		PrefixSymbols: "@+",
+		ChanModes:     [4]string{"beI", "k", "l", "imnpst"},
+		CaseMapping:   "rfc1459",
+		NickLen:       9,
+		Modes:         3,
+		TargMax:       make(map[string]int),
+		LineLen:       512,
+		Raw:           make(map[string]string),
+	}
+}
+
+// IsChannel returns true if the given target is a channel name.
+func (i *ISupport) IsChannel(target string) bool {
+	return target != "" && strings.IndexByte(i.ChanTypes, target[0]) >= 0
+}
+
+// ToLower converts the given nick or channel to lower case using the
+// server's casemapping.
+func (i *ISupport) ToLower(s string) string {
+	var upper, lower string
+
+	switch i.CaseMapping {
+	case "ascii":
+		return strings.Map(asciiToLower, s)
+	case "rfc1459-strict":
+		upper, lower = `[]\`, `{}|`
+	default:
+		upper, lower = `[]\~`, `{}|^`
+	}
+
+	return strings.Map(func(r rune) rune {
+		if idx := strings.IndexRune(upper, r); idx >= 0 {
+			return rune(lower[idx])
+		}
+
+		return asciiToLower(r)
+	}, s)
+}
+
+// EqualFold returns true if the two nicks or channels are equal under the
+// server's casemapping.
+func (i *ISupport) EqualFold(a, b string) bool {
+	return i.ToLower(a) == i.ToLower(b)
+}
+
+func asciiToLower(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + ('a' - 'A')
+	}
+
+	return r
+}
+
+// copy returns a deep copy of the ISupport.
+func (i *ISupport) copy() *ISupport {
+	ret := *i
+
+	ret.TargMax = make(map[string]int, len(i.TargMax))
+	for k, v := range i.TargMax {
+		ret.TargMax[k] = v
+	}
+
+	ret.Raw = make(map[string]string, len(i.Raw))
+	for k, v := range i.Raw {
+		ret.Raw[k] = v
+	}
+
+	return &ret
+}
+
+// withMessage returns a copy of the ISupport updated with the tokens from the
+// given RPL_ISUPPORT message. The original is not modified so it can be safely
+// shared between goroutines.
+func (i *ISupport) withMessage(m *irc.Message) *ISupport {
+	ret := i.copy()
+
+	// The first param is our nick and the last is "are supported by this
+	// server".
+	if len(m.Params) < 3 {
+		return ret
+	}
+
+	defaults := DefaultISupport()
+
+	for _, token := range m.Params[1 : len(m.Params)-1] {
+		// A token starting with a - means the value should be reset to the
+		// default.
+		if strings.HasPrefix(token, "-") {
+			key := token[1:]
+			delete(ret.Raw, key)
+			ret.apply(key, defaults.Raw[key], defaults)
+
+			continue
+		}
+
+		kv := strings.SplitN(token, "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+
+		ret.Raw[kv[0]] = kv[1]
+		ret.apply(kv[0], kv[1], defaults)
+	}
+
+	return ret
+}
+
+//nolint:funlen
+func (i *ISupport) apply(key, value string, defaults *ISupport) {
+	switch key {
+	case "CHANTYPES":
+		i.ChanTypes = value
+		if _, ok := i.Raw[key]; !ok {
+			i.ChanTypes = defaults.ChanTypes
+		}
+	case "PREFIX":
+		i.PrefixModes, i.PrefixSymbols = defaults.PrefixModes, defaults.PrefixSymbols
+
+		if value == "" {
+			if _, ok := i.Raw[key]; ok {
+				i.PrefixModes, i.PrefixSymbols = "", ""
+			}
+
+			return
+		}
+
+		// PREFIX is in the form (modes)symbols
+		if value[0] != '(' {
+			return
+		}
+
+		parts := strings.SplitN(value[1:], ")", 2)
+		if len(parts) == 2 && len(parts[0]) == len(parts[1]) {
+			i.PrefixModes, i.PrefixSymbols = parts[0], parts[1]
+		}
+	case "CHANMODES":
+		i.ChanModes = defaults.ChanModes
+
+		if value != "" {
+			i.ChanModes = [4]string{}
+			copy(i.ChanModes[:], strings.SplitN(value, ",", 4))
+		}
+	case "CASEMAPPING":
+		i.CaseMapping = defaults.CaseMapping
+		if value == "ascii" || value == "rfc1459-strict" {
+			i.CaseMapping = value
+		}
+	case "NICKLEN":
+		i.NickLen = atoiDefault(value, defaults.NickLen)
+	case "MODES":
+		// An empty MODES value means there is no limit.
+		i.Modes = atoiDefault(value, defaults.Modes)
+		if _, ok := i.Raw[key]; ok && value == "" {
+			i.Modes = 0
+		}
+	case "TARGMAX":
+		i.TargMax = make(map[string]int)
+
+		for _, item := range strings.Split(value, ",") {
+			kv := strings.SplitN(item, ":", 2)
+			if len(kv) == 2 && kv[0] != "" {
+				i.TargMax[strings.ToUpper(kv[0])] = atoiDefault(kv[1], 0)
+			}
+		}
+	case "LINELEN":
+		i.LineLen = atoiDefault(value, defaults.LineLen)
+	case "NETWORK":
+		i.Network = value
+	}
+}
+
+func atoiDefault(value string, def int) int {
+	ret, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+
+	return ret
+}
@@ -0,0 +1,65 @@
+package seabird_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	irc "gopkg.in/irc.v3"
+
+	"github.com/belak/go-seabird"
+)
+
+func TestISupportDefaults(t *testing.T) {
+	isupport := seabird.DefaultISupport()
+
+	assert.True(t, isupport.IsChannel("#chan"))
+	assert.True(t, isupport.IsChannel("&chan"))
+	assert.False(t, isupport.IsChannel("nick"))
+	assert.False(t, isupport.IsChannel(""))
+
+	assert.Equal(t, "nick{}|^", isupport.ToLower("NICK[]\\~"))
+	assert.True(t, isupport.EqualFold("Nick[a]", "nick{A}"))
+
+	// Requests without a bot should fall back to the defaults.
+	r := seabird.NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage(":belak PRIVMSG &hello :hi"))
+	assert.True(t, r.FromChannel())
+}
+
+func TestISupportParsing(t *testing.T) {
+	b := newTestBot(t, "")
+
+	var isupport *seabird.ISupport
+
+	b.BasicMux().Event("PRIVMSG", func(r *seabird.Request) {
+		isupport = r.ISupport()
+	})
+
+	runTestBot(t, b,
+		"001 bot :Welcome",
+		"005 bot CHANTYPES=#! PREFIX=(qaohv)~&@%+ CASEMAPPING=ascii NICKLEN=30 MODES :are supported by this server",
+		"005 bot TARGMAX=PRIVMSG:4,NOTICE:4,JOIN: LINELEN=1024 NETWORK=TestNet CHANMODES=b,k,l,imnt :are supported by this server",
+		"005 bot FOO=bar -NICKLEN :are supported by this server",
+		":belak PRIVMSG !chan :hello",
+	)
+
+	require.NotNil(t, isupport)
+	assert.Equal(t, "#!", isupport.ChanTypes)
+	assert.True(t, isupport.IsChannel("!chan"))
+	assert.False(t, isupport.IsChannel("&chan"))
+	assert.Equal(t, "qaohv", isupport.PrefixModes)
+	assert.Equal(t, "~&@%+", isupport.PrefixSymbols)
+	assert.Equal(t, "ascii", isupport.CaseMapping)
+	assert.Equal(t, "nick[]", isupport.ToLower("NICK[]"))
+	assert.Equal(t, 9, isupport.NickLen)
+	assert.Equal(t, 0, isupport.Modes)
+	assert.Equal(t, map[string]int{"PRIVMSG": 4, "NOTICE": 4, "JOIN": 0}, isupport.TargMax)
+	assert.Equal(t, 1024, isupport.LineLen)
+	assert.Equal(t, "TestNet", isupport.Network)
+	assert.Equal(t, [4]string{"b", "k", "l", "imnt"}, isupport.ChanModes)
+	assert.Equal(t, "bar", isupport.Raw["FOO"])
+
+	// The tracker should use the server's prefixes.
+	assert.Equal(t, "~&@%+", b.ISupport().PrefixSymbols)
+}
@@ -0,0 +1,81 @@
+package seabird
+
+import (
+	"strings"
+
+	irc "gopkg.in/irc.v3"
+)
+
+const (
+	// These are used to estimate the length of our own prefix if the server
+	// hasn't told us what it is yet.
+	defaultUserLen = 10
+	defaultHostLen = 63
+
+	// rplHostHidden is sent when the server changes our displayed host. It
+	// is missing from the irc package.
+	rplHostHidden = "396"
+)
+
+// maxMessageLen returns the maximum number of bytes which can be sent in the
+// trailing param of a message with the given command and target. This takes
+// into account the prefix the server will add when relaying the message to
+// other clients.
+func (b *Bot) maxMessageLen(currentNick, command, target string) int {
+	b.connLock.RLock()
+	lineLen := b.isupport.LineLen
+	user, host := b.selfUser, b.selfHost
+	b.connLock.RUnlock()
+
+	userLen := len(user)
+	if userLen == 0 {
+		userLen = defaultUserLen
+	}
+
+	hostLen := len(host)
+	if hostLen == 0 {
+		hostLen = defaultHostLen
+	}
+
+	// :nick!user@host COMMAND target :message\r\n
+	overhead := 1 + len(currentNick) + 1 + userLen + 1 + hostLen +
+		1 + len(command) + 1 + len(target) + 2 + 2
+
+	return lineLen - overhead
+}
+
+// updateSelfPrefix keeps track of the user and host the server is using for
+// us so we can properly calculate the maximum message length.
+func (b *Bot) updateSelfPrefix(c *irc.Client, m *irc.Message) {
+	var user, host string
+
+	switch {
+	case m.Command == irc.RPL_WELCOME:
+		// Most servers include our full prefix as the last word of the
+		// welcome message.
+		words := strings.Fields(m.Trailing())
+		if len(words) == 0 {
+			return
+		}
+
+		p := irc.ParsePrefix(words[len(words)-1])
+		user, host = p.User, p.Host
+	case m.Command == rplHostHidden && len(m.Params) > 1:
+		host = m.Params[1]
+	case m.Command == "CHGHOST" && m.Prefix.Name == c.CurrentNick() && len(m.Params) > 1:
+		user, host = m.Params[0], m.Params[1]
+	case m.Prefix != nil && m.Prefix.Name == c.CurrentNick():
+		user, host = m.Prefix.User, m.Prefix.Host
+	}
+
+	b.connLock.Lock()
+	defer b.connLock.Unlock()
+
+	if user != "" {
+		b.selfUser = user
+	}
+
+	if host != "" {
+		b.selfHost = host
+	}
+}
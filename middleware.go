@@ -0,0 +1,56 @@
+package seabird
+
+// Middleware wraps a HandlerFunc to add cross-cutting behavior like auth
+// checks, logging or timing. A Middleware can decide not to call the next
+// HandlerFunc at all.
+//
+// Middleware can be added to a whole mux with Use or to a single registration
+// by passing it to Event. Global middleware always runs before
+// per-registration middleware, and within each group middleware runs in the
+// order it was added, so with
+//
+//	mux.Use(a, b)
+//	mux.Event("PRIVMSG", h, c, d)
+//
+// a request will go through a, b, c and d before reaching h. Global middleware
+// applies to all handlers, even ones registered before Use was called, until
+// the Registration returned by Use is removed.
+type Middleware func(HandlerFunc) HandlerFunc
+
+// applyMiddleware wraps the handler so the middleware will run in order.
+func applyMiddleware(h HandlerFunc, middleware []Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	return h
+}
+
+// muxEntry is a single handler registered with a mux.
+type muxEntry struct {
+	// handler is the HandlerFunc which was originally registered. It is kept
+	// around so panics can be attributed to the right function.
+	handler HandlerFunc
+
+	// wrapped is the handler with any per-registration middleware applied.
+	wrapped HandlerFunc
+}
+
+func newMuxEntry(h HandlerFunc, middleware []Middleware) *muxEntry {
+	return &muxEntry{
+		handler: h,
+		wrapped: applyMiddleware(h, middleware),
+	}
+}
+
+// call runs the handler with the given global middleware, recovering from any
+// panics.
+func (e *muxEntry) call(r *Request, middleware []Middleware, replyOnPanic bool) {
+	// Muxes can be nested, so the handler is restored once this one is done.
+	prev := r.handler
+	r.handler = e.handler
+
+	defer func() { r.handler = prev }()
+
+	callHandler(r, e.handler, applyMiddleware(e.wrapped, middleware), replyOnPanic)
+}
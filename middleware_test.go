@@ -0,0 +1,84 @@
+package seabird_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	irc "gopkg.in/irc.v3"
+
+	"github.com/belak/go-seabird"
+)
+
+type middlewareRecorder struct {
+	calls []string
+}
+
+func (mr *middlewareRecorder) middleware(name string) seabird.Middleware {
+	return func(next seabird.HandlerFunc) seabird.HandlerFunc {
+		return func(r *seabird.Request) {
+			mr.calls = append(mr.calls, name)
+			next(r)
+		}
+	}
+}
+
+func (mr *middlewareRecorder) handler(name string) seabird.HandlerFunc {
+	return func(r *seabird.Request) {
+		mr.calls = append(mr.calls, name)
+	}
+}
+
+func TestBasicMuxMiddleware(t *testing.T) {
+	r := seabird.NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage("001"))
+
+	mr := &middlewareRecorder{}
+	mux := seabird.NewBasicMux()
+
+	// Global middleware should apply to handlers registered before Use.
+	mux.Event("001", mr.handler("h1"), mr.middleware("c"), mr.middleware("d"))
+	mux.Use(mr.middleware("a"), mr.middleware("b"))
+	mux.Event("001", mr.handler("h2"))
+
+	mux.HandleEvent(r)
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "h1", "a", "b", "h2"}, mr.calls)
+
+	// Middleware can stop the handler from running.
+	mr = &middlewareRecorder{}
+	mux = seabird.NewBasicMux()
+	mux.Use(func(next seabird.HandlerFunc) seabird.HandlerFunc {
+		return func(r *seabird.Request) {}
+	})
+	mux.Event("001", mr.handler("h"))
+
+	mux.HandleEvent(r)
+
+	assert.Empty(t, mr.calls)
+}
+
+func TestCommandMuxMiddleware(t *testing.T) {
+	mr := &middlewareRecorder{}
+	mux := seabird.NewCommandMux("!")
+
+	mux.Use(mr.middleware("global"))
+	mux.Event("hello", mr.handler("hello"), nil, mr.middleware("local"))
+	mux.Channel("other", mr.handler("other"), nil)
+
+	mux.HandleEvent(seabird.NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage(":belak PRIVMSG #hello :!hello")))
+	mux.HandleEvent(seabird.NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage(":belak PRIVMSG #hello :!other")))
+
+	assert.Equal(t, []string{"global", "local", "hello", "global", "other"}, mr.calls)
+}
+
+func TestMentionMuxMiddleware(t *testing.T) {
+	mr := &middlewareRecorder{}
+	mux := seabird.NewMentionMux()
+
+	mux.Event(mr.handler("h"), mr.middleware("local"))
+	mux.Use(mr.middleware("global"))
+
+	mux.HandleEvent(seabird.NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage(":belak PRIVMSG #hello :bot: hi")))
+
+	assert.Equal(t, []string{"global", "local", "h"}, mr.calls)
+}
@@ -10,44 +10,116 @@ import (
 // Handlers will be processed in the order in which they were added.
 // Registering a handler with a "*" command will cause it to receive all events.
 // Note that even though "*" will match all commands, glob matching is not used.
+//
+// Any panics in handlers will be recovered and logged so they don't take down
+// the bot.
 type BasicMux struct {
-	m  map[string][]HandlerFunc
-	mu *sync.Mutex
+	m          map[string][]*muxEntry
+	uses       []*middlewareGroup
+	middleware []Middleware
+	mu         *sync.Mutex
+
+	// replyOnPanic is set for muxes which only handle messages from users,
+	// so it makes sense to tell them something went wrong.
+	replyOnPanic bool
+
+	// onRegister is called with every new Registration so the bot can keep
+	// track of which plugin registered what.
+	onRegister func(*Registration)
 }
 
 // NewBasicMux will create an initialized BasicMux with no handlers.
 func NewBasicMux() *BasicMux {
 	return &BasicMux{
-		make(map[string][]HandlerFunc),
+		make(map[string][]*muxEntry),
+		nil,
+		nil,
 		&sync.Mutex{},
+		false,
+		nil,
+	}
+}
+
+// Use adds middleware which will be applied to every handler in this mux. The
+// returned Registration can be used to remove the middleware.
+func (mux *BasicMux) Use(middleware ...Middleware) *Registration {
+	group := &middlewareGroup{middleware}
+
+	mux.mu.Lock()
+	mux.uses = append(mux.uses, group)
+	mux.middleware = flattenMiddleware(mux.uses)
+	mux.mu.Unlock()
+
+	reg := newRegistration(func() {
+		mux.mu.Lock()
+		defer mux.mu.Unlock()
+
+		mux.uses = removeMiddleware(mux.uses, group)
+		mux.middleware = flattenMiddleware(mux.uses)
+	})
+
+	if mux.onRegister != nil {
+		mux.onRegister(reg)
 	}
+
+	return reg
 }
 
-// Event will register a Handler.
-func (mux *BasicMux) Event(c string, h HandlerFunc) {
+// Event will register a Handler. Any middleware passed in will only apply to
+// this handler. The returned Registration can be used to remove the handler.
+func (mux *BasicMux) Event(c string, h HandlerFunc, middleware ...Middleware) *Registration {
+	entry := newMuxEntry(h, middleware)
+
+	mux.mu.Lock()
+	mux.m[c] = append(mux.m[c], entry)
+	mux.mu.Unlock()
+
+	reg := newRegistration(func() {
+		mux.mu.Lock()
+		defer mux.mu.Unlock()
+
+		mux.m[c] = removeEntry(mux.m[c], entry)
+		if len(mux.m[c]) == 0 {
+			delete(mux.m, c)
+		}
+	})
+
+	if mux.onRegister != nil {
+		mux.onRegister(reg)
+	}
+
+	return reg
+}
+
+// hasHandlers returns true if there are any handlers for the given command.
+func (mux *BasicMux) hasHandlers(c string) bool {
 	mux.mu.Lock()
 	defer mux.mu.Unlock()
 
-	mux.m[c] = append(mux.m[c], h)
+	return len(mux.m[c]) > 0
 }
 
 // HandleEvent allows us to be a Handler so we can nest Handlers.
 //
 // The BasicMux simply dispatches all the Handler commands as needed.
 func (mux *BasicMux) HandleEvent(r *Request) {
-	// Lock our handlers so we don't crap bricks if a
-	// handler is added or removed from under our feet.
+	// Grab the handlers while we have the lock so we don't crap bricks if a
+	// handler is added or removed from under our feet. We don't hold the lock
+	// while calling them so handlers can add or remove other handlers.
 	mux.mu.Lock()
-	defer mux.mu.Unlock()
+	middleware := mux.middleware
+	globalHandlers := mux.m["*"]
+	handlers := mux.m[r.Message.Command]
+	mux.mu.Unlock()
 
 	// Star means ALL THE THINGS. Really, this is only useful for logging.
-	for _, h := range mux.m["*"] {
-		h(r)
+	for _, h := range globalHandlers {
+		h.call(r, middleware, mux.replyOnPanic)
 	}
 
 	// Now that we've done the global handlers, we can run the ones specific to
 	// this command.
-	for _, handler := range mux.m[r.Message.Command] {
-		handler(r)
+	for _, handler := range handlers {
+		handler.call(r, middleware, mux.replyOnPanic)
 	}
 }
@@ -58,3 +58,19 @@ func TestBasicMux(t *testing.T) {
 	mux = seabird.NewBasicMux()
 	mux.HandleEvent(r)
 }
+
+func TestBasicMuxPanic(t *testing.T) {
+	r := seabird.NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage("001"))
+
+	// A panicking handler shouldn't stop other handlers from running
+	mh := &messageHandler{}
+	mux := seabird.NewBasicMux()
+	mux.Event("001", func(r *seabird.Request) {
+		var m map[string]int
+		m["boom"]++
+	})
+	mux.Event("001", mh.Handle)
+
+	require.NotPanics(t, func() { mux.HandleEvent(r) })
+	require.Equal(t, 1, mh.count)
+}
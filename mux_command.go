@@ -1,8 +1,12 @@
 package seabird
 
 import (
+	"fmt"
 	"sort"
 	"strings"
+	"sync"
+
+	"github.com/belak/go-seabird/internal"
 )
 
 // CommandMux is a simple IRC event multiplexer, based on the BasicMux.
@@ -13,6 +17,43 @@ type HelpInfo struct {
 	name        string
 	Usage       string
 	Description string
+
+	// Args and Flags are optional. If either is set, the command line will be
+	// parsed before the handler is called and the values will be available
+	// with Request.Args. If Usage is empty, it will be generated from them.
+	Args  []Arg
+	Flags []Flag
+
+	// Aliases are other names the command can be called with. They aren't
+	// listed separately in help.
+	Aliases []string
+
+	// Permission is required to call the command if set. Anyone without it
+	// will get a "Permission denied" reply.
+	Permission string
+
+	// RateLimits limit how often the command can be called. Every limit must
+	// allow the call for the handler to run.
+	RateLimits []RateLimit
+}
+
+// validate makes sure the arguments and rate limits are usable.
+func (h *HelpInfo) validate() error {
+	if h == nil {
+		return nil
+	}
+
+	if err := h.validateArgs(); err != nil {
+		return err
+	}
+
+	for i, limit := range h.RateLimits {
+		if err := limit.validate(); err != nil {
+			return fmt.Errorf("rate limit %d: %w", i, err)
+		}
+	}
+
+	return nil
 }
 
 // The CommandMux is given a prefix string and matches all PRIVMSG
@@ -23,8 +64,34 @@ type CommandMux struct {
 	public  *BasicMux
 	prefix  string
 	cmdHelp map[string]*HelpInfo
+	aliases map[string]string
+	lock    *sync.RWMutex
+	suggest bool
+
+	// channelPrefixes overrides the prefix for specific channels and
+	// mentionPrefix allows the bot's nick to be used as a prefix.
+	channelPrefixes map[string][]string
+	mentionPrefix   bool
+
+	// rateLimits are set from the config and override the limits commands
+	// were registered with.
+	limiter    *rateLimiter
+	rateLimits map[string]RateLimit
+
+	onRegister func(*Registration)
 }
 
+// commandISupport is used to normalize command names. Commands are generally
+// registered before we know anything about the server, so the same casemapping
+// has to be used when they are called, no matter what the server's CASEMAPPING
+// is, or commands containing characters like [ or ~ would stop matching.
+//
+// This is intentionally fixed to rfc1459. It folds everything ascii and
+// strict-rfc1459 do, so any two command names the server would consider
+// equal are also equal here. The only difference is that names like "cmd[" and
+// "cmd{" are treated as the same command on servers with a narrower mapping.
+var commandISupport = DefaultISupport()
+
 // NewCommandMux will create an initialized BasicMux with no handlers.
 func NewCommandMux(prefix string) *CommandMux {
 	m := &CommandMux{
@@ -32,23 +99,49 @@ func NewCommandMux(prefix string) *CommandMux {
 		NewBasicMux(),
 		prefix,
 		make(map[string]*HelpInfo),
+		make(map[string]string),
+		&sync.RWMutex{},
+		false,
+		make(map[string][]string),
+		false,
+		newRateLimiter(),
+		make(map[string]RateLimit),
+		nil,
 	}
 
+	// Commands always come from users, so let them know if something broke.
+	m.private.replyOnPanic = true
+	m.public.replyOnPanic = true
+
 	m.Event("help", m.help, &HelpInfo{
-		"help",
-		"<command>",
-		"Displays help messages for a given command",
+		Usage:       "<command>",
+		Description: "Displays help messages for a given command",
 	})
 
 	return m
 }
 
 func (m *CommandMux) help(r *Request) {
-	cmd := r.Message.Trailing()
+	// Make a copy of the help so commands can be added or removed while we're
+	// using it.
+	m.lock.RLock()
+	cmdHelp := make(map[string]*HelpInfo, len(m.cmdHelp))
+	for k, v := range m.cmdHelp {
+		cmdHelp[k] = v
+	}
+	cmd := normalizeCommand(r.Message.Trailing())
+
+	if primary, ok := m.aliases[cmd]; ok {
+		cmd = primary
+	}
+	m.lock.RUnlock()
+
+	prefix := m.PrefixFor(r)
+
 	if cmd == "" {
 		// Get all keys
-		keys := make([]string, 0, len(m.cmdHelp))
-		for k := range m.cmdHelp {
+		keys := make([]string, 0, len(cmdHelp))
+		for k := range cmdHelp {
 			keys = append(keys, k)
 		}
 
@@ -56,30 +149,81 @@ func (m *CommandMux) help(r *Request) {
 		sort.Strings(keys)
 
 		if r.FromChannel() {
-			// If they said "!help" in a channel, list all available commands
-			r.Replyf("Available commands: %s. Use %shelp [command] for more info.", strings.Join(keys, ", "), m.prefix)
+			// If they said "!help" in a channel, list all available top level
+			// commands.
+			var topLevel []string
+			for _, k := range keys {
+				topLevel = internal.AppendStr(topLevel, strings.SplitN(k, " ", 2)[0])
+			}
+
+			r.Replyf("Available commands: %s. Use %shelp [command] for more info.", strings.Join(topLevel, ", "), prefix)
 		} else {
 			for _, v := range keys {
-				h := m.cmdHelp[v]
-				if h.Usage != "" {
+				h := cmdHelp[v]
+				if h == nil {
+					r.Replyf("%s", v)
+				} else if h.Usage != "" {
 					r.Replyf("%s %s: %s", v, h.Usage, h.Description)
 				} else {
 					r.Replyf("%s: %s", v, h.Description)
 				}
 			}
 		}
-	} else if help, ok := m.cmdHelp[cmd]; ok {
-		if help == nil {
-			r.Replyf("There is no help available for command %q", cmd)
-		} else {
-			lines := help.format(m.prefix, cmd)
-			for _, line := range lines {
-				r.Replyf("%s", line)
-			}
+
+		return
+	}
+
+	help, ok := cmdHelp[cmd]
+	subcommands := subcommandsOf(cmdHelp, cmd)
+
+	switch {
+	case ok && help != nil:
+		lines := help.format(prefix, cmd)
+		for _, line := range lines {
+			r.Replyf("%s", line)
 		}
-	} else {
+	case ok:
+		r.Replyf("There is no help available for command %q", cmd)
+	case len(subcommands) == 0:
 		r.MentionReplyf("There is no help available for command %q", cmd)
 	}
+
+	if len(subcommands) > 0 {
+		r.Replyf("Subcommands: %s. Use %shelp %s [subcommand] for more info.", strings.Join(subcommands, ", "), prefix, cmd)
+	}
+}
+
+// normalizeCommand lowercases a command and collapses any whitespace between
+// the parts of a subcommand.
+func normalizeCommand(c string) string {
+	return commandISupport.ToLower(strings.Join(strings.Fields(c), " "))
+}
+
+// subcommandsOf returns the names of the direct subcommands of the given
+// command.
+func subcommandsOf(cmdHelp map[string]*HelpInfo, cmd string) []string {
+	var ret []string
+
+	for k := range cmdHelp {
+		if !strings.HasPrefix(k, cmd+" ") {
+			continue
+		}
+
+		ret = internal.AppendStr(ret, strings.SplitN(strings.TrimPrefix(k, cmd+" "), " ", 2)[0])
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// subcommands returns the names of the direct subcommands of the given
+// command.
+func (m *CommandMux) subcommands(cmd string) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return subcommandsOf(m.cmdHelp, cmd)
 }
 
 func (h *HelpInfo) format(prefix, command string) []string {
@@ -97,80 +241,420 @@ func (h *HelpInfo) format(prefix, command string) []string {
 		ret = append(ret, h.Description)
 	}
 
+	if len(h.Aliases) > 0 {
+		ret = append(ret, "Aliases: "+strings.Join(h.Aliases, ", "))
+	}
+
+	if h.Permission != "" {
+		ret = append(ret, "Requires the "+h.Permission+" permission")
+	}
+
 	return ret
 }
 
-// Event will register a Handler as both a private and public command.
-func (m *CommandMux) Event(c string, h HandlerFunc, help *HelpInfo) {
-	if help != nil {
-		help.name = c
+// Prefix returns the default command prefix for this mux.
+func (m *CommandMux) Prefix() string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.prefix
+}
+
+// SetPrefix changes the default command prefix for this mux.
+func (m *CommandMux) SetPrefix(prefix string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.prefix = prefix
+}
+
+// SetChannelPrefixes replaces the per-channel prefixes. Channels which aren't
+// in the map use the default prefix. A channel with no prefixes can only use
+// commands if the mention prefix is enabled.
+func (m *CommandMux) SetChannelPrefixes(prefixes map[string][]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.channelPrefixes = make(map[string][]string, len(prefixes))
+	for channel, channelPrefixes := range prefixes {
+		m.channelPrefixes[channel] = append([]string(nil), channelPrefixes...)
 	}
+}
 
-	c = strings.ToLower(c)
+// SetMentionPrefix controls whether addressing the bot by nick, like
+// "seabird: weather", can be used in place of a prefix.
+func (m *CommandMux) SetMentionPrefix(enabled bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-	m.private.Event(c, h)
-	m.public.Event(c, h)
+	m.mentionPrefix = enabled
+}
 
-	m.cmdHelp[c] = help
+// SetRateLimits replaces the rate limits for the given commands. Commands
+// which aren't in the map use the limits they were registered with. It panics
+// if any of the limits are invalid.
+func (m *CommandMux) SetRateLimits(limits map[string]RateLimit) {
+	for command, limit := range limits {
+		if err := limit.validate(); err != nil {
+			panic(fmt.Sprintf("Rate limit for %q: %s", command, err))
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.rateLimits = make(map[string]RateLimit, len(limits))
+	for command, limit := range limits {
+		m.rateLimits[normalizeCommand(command)] = limit
+	}
 }
 
-// Channel will register a handler as a public command.
-func (m *CommandMux) Channel(c string, h HandlerFunc, help *HelpInfo) {
-	if help != nil {
-		help.name = c
+// prefixes returns the prefixes which are valid for the given request.
+func (m *CommandMux) prefixes(r *Request) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if r.FromChannel() {
+		for channel, prefixes := range m.channelPrefixes {
+			if r.ISupport().EqualFold(channel, r.Message.Params[0]) {
+				return prefixes
+			}
+		}
 	}
 
-	c = strings.ToLower(c)
+	return []string{m.prefix}
+}
+
+// PrefixFor returns the command prefix which should be shown to users in
+// replies to the given request.
+func (m *CommandMux) PrefixFor(r *Request) string {
+	if prefixes := m.prefixes(r); len(prefixes) > 0 {
+		return prefixes[0]
+	}
 
-	m.public.Event(c, h)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 
-	m.cmdHelp[c] = help
+	if m.mentionPrefix {
+		return r.CurrentNick() + ": "
+	}
+
+	return m.prefix
+}
+
+// stripPrefix removes any valid prefix from the message. It returns the
+// message without the prefix and the prefix which matched.
+func (m *CommandMux) stripPrefix(r *Request, msg string) (string, string, bool) {
+	prefixes := m.prefixes(r)
+
+	// Try longer prefixes first so "!!" isn't matched as "!".
+	sorted := make([]string, len(prefixes))
+	copy(sorted, prefixes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	for _, prefix := range sorted {
+		if prefix != "" && strings.HasPrefix(msg, prefix) {
+			return strings.TrimPrefix(msg, prefix), prefix, true
+		}
+	}
+
+	m.lock.RLock()
+	mentionPrefix := m.mentionPrefix
+	m.lock.RUnlock()
+
+	if mentionPrefix {
+		if rest, ok := stripMention(r, msg); ok {
+			return rest, r.CurrentNick() + ": ", true
+		}
+	}
+
+	return msg, "", false
+}
+
+// SetSuggestions controls whether the mux will reply with the closest matching
+// commands when a message starts with the prefix but doesn't match any
+// command.
+func (m *CommandMux) SetSuggestions(enabled bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.suggest = enabled
 }
 
-// Private will register a handler as a private command.
-func (m *CommandMux) Private(c string, h HandlerFunc, help *HelpInfo) {
+// Use adds middleware which will be applied to every command in this mux.
+// The returned Registration can be used to remove the middleware.
+func (m *CommandMux) Use(middleware ...Middleware) *Registration {
+	regs := []*Registration{
+		m.private.Use(middleware...),
+		m.public.Use(middleware...),
+	}
+
+	reg := newRegistration(func() {
+		for _, reg := range regs {
+			reg.Remove()
+		}
+	})
+
+	if m.onRegister != nil {
+		m.onRegister(reg)
+	}
+
+	return reg
+}
+
+// Event will register a Handler as both a private and public command. Any
+// middleware passed in will only apply to this command. The returned
+// Registration can be used to remove the command.
+func (m *CommandMux) Event(c string, h HandlerFunc, help *HelpInfo, middleware ...Middleware) *Registration {
+	return m.register(c, h, help, []*BasicMux{m.private, m.public}, middleware)
+}
+
+// Channel will register a handler as a public command. Any middleware passed
+// in will only apply to this command. The returned Registration can be used to
+// remove the command.
+func (m *CommandMux) Channel(c string, h HandlerFunc, help *HelpInfo, middleware ...Middleware) *Registration {
+	return m.register(c, h, help, []*BasicMux{m.public}, middleware)
+}
+
+// Private will register a handler as a private command. Any middleware passed
+// in will only apply to this command. The returned Registration can be used to
+// remove the command.
+func (m *CommandMux) Private(c string, h HandlerFunc, help *HelpInfo, middleware ...Middleware) *Registration {
+	return m.register(c, h, help, []*BasicMux{m.private}, middleware)
+}
+
+func (m *CommandMux) register(c string, h HandlerFunc, help *HelpInfo, muxes []*BasicMux, middleware []Middleware) *Registration {
+	c = normalizeCommand(c)
+
+	// A bad argument spec or rate limit is a bug in the plugin, so it's
+	// treated like registering a plugin twice. When this happens in a
+	// PluginFactory, the plugin fails to load.
+	if err := help.validate(); err != nil {
+		panic(fmt.Sprintf("Command %q: %s", c, err))
+	}
+
+	var limits []RateLimit
+	if help != nil {
+		limits = help.RateLimits
+	}
+
+	// Permissions and rate limits are checked before any per-registration
+	// middleware, but global middleware added with Use still runs first. Rate
+	// limits are always added since they can be set in the config.
+	middleware = append([]Middleware{m.rateLimitMiddleware(c, limits)}, middleware...)
+
 	if help != nil {
 		help.name = c
+
+		if help.Permission != "" {
+			middleware = append([]Middleware{RequirePermission(help.Permission)}, middleware...)
+		}
+
+		if help.hasArgs() {
+			if help.Usage == "" {
+				help.Usage = help.generateUsage()
+			}
+
+			// Parsing needs to happen right before the handler is called so
+			// it's added after any other middleware.
+			middleware = append(middleware[:len(middleware):len(middleware)], m.argsMiddleware(help))
+		}
+	}
+
+	var aliases []string
+
+	if help != nil {
+		for _, alias := range help.Aliases {
+			aliases = internal.AppendStr(aliases, normalizeCommand(alias))
+		}
 	}
 
-	c = strings.ToLower(c)
+	regs := make([]*Registration, 0, len(muxes)*(len(aliases)+1))
+	for _, mux := range muxes {
+		regs = append(regs, mux.Event(c, h, middleware...))
 
-	m.private.Event(c, h)
+		for _, alias := range aliases {
+			regs = append(regs, mux.Event(alias, h, middleware...))
+		}
+	}
 
+	m.lock.Lock()
 	m.cmdHelp[c] = help
+	for _, alias := range aliases {
+		m.aliases[alias] = c
+	}
+	m.lock.Unlock()
+
+	reg := newRegistration(func() {
+		for _, reg := range regs {
+			reg.Remove()
+		}
+
+		// Only remove the help if nothing else registered the same command.
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		if !m.private.hasHandlers(c) && !m.public.hasHandlers(c) {
+			delete(m.cmdHelp, c)
+		}
+
+		for _, alias := range aliases {
+			if m.aliases[alias] == c && !m.private.hasHandlers(alias) && !m.public.hasHandlers(alias) {
+				delete(m.aliases, alias)
+			}
+		}
+	})
+
+	if m.onRegister != nil {
+		m.onRegister(reg)
+	}
+
+	return reg
 }
 
 // HandleEvent strips off the prefix, pulls the command out
 // and runs HandleEvent on the internal BasicMux.
 func (m *CommandMux) HandleEvent(r *Request) {
+	m.handle(r)
+}
+
+// handle is HandleEvent, but it returns true if the message was handled as a
+// command, either by a handler or with a usage message for a command with
+// subcommands.
+func (m *CommandMux) handle(r *Request) bool {
 	if r.Message.Command != "PRIVMSG" {
 		// TODO: Log this
-		return
+		return false
 	}
 
-	// Get the last arg and see if it starts with the command prefix
-	lastArg := r.Message.Trailing()
-	if r.FromChannel() && !strings.HasPrefix(lastArg, m.prefix) {
-		return
+	// Get the last arg and see if it starts with the command prefix. Private
+	// messages don't need a prefix.
+	msg, prefix, hasPrefix := m.stripPrefix(r, r.Message.Trailing())
+	if r.FromChannel() && !hasPrefix {
+		return false
+	}
+
+	if !hasPrefix {
+		prefix = m.PrefixFor(r)
 	}
 
 	// Copy it into a new Event
 	newRequest := r.Copy()
 
 	// Chop off the command itself
-	msgParts := strings.SplitN(lastArg, " ", 2)
-	newRequest.Message.Params[len(newRequest.Message.Params)-1] = ""
+	msgParts := strings.SplitN(msg, " ", 2)
+	rest := ""
 
 	if len(msgParts) > 1 {
-		newRequest.Message.Params[len(newRequest.Message.Params)-1] = strings.TrimSpace(msgParts[1])
+		rest = strings.TrimSpace(msgParts[1])
 	}
 
-	newRequest.Message.Command = strings.ToLower(msgParts[0])
-	newRequest.Message.Command = strings.TrimPrefix(newRequest.Message.Command, m.prefix)
+	cmd := normalizeCommand(msgParts[0])
 
+	mux := m.private
 	if newRequest.FromChannel() {
-		m.public.HandleEvent(newRequest)
-	} else {
-		m.private.HandleEvent(newRequest)
+		mux = m.public
+	}
+
+	// Walk down any subcommands so "karma top 5" is dispatched to "karma top"
+	// with the arguments "5".
+	for rest != "" {
+		parts := strings.SplitN(rest, " ", 2)
+		candidate := cmd + " " + normalizeCommand(parts[0])
+
+		if !mux.hasHandlers(candidate) && len(m.subcommands(candidate)) == 0 {
+			break
+		}
+
+		cmd, rest = candidate, ""
+		if len(parts) > 1 {
+			rest = strings.TrimSpace(parts[1])
+		}
+	}
+
+	newRequest.Message.Command = cmd
+	newRequest.Message.Params[len(newRequest.Message.Params)-1] = rest
+
+	if !mux.hasHandlers(cmd) {
+		if subcommands := m.subcommands(cmd); len(subcommands) > 0 {
+			newRequest.MentionReplyf("Usage: %s%s <%s>", prefix, cmd, strings.Join(subcommands, "|"))
+			return true
+		} else if hasPrefix {
+			m.suggestCommands(newRequest, prefix, cmd)
+		}
+
+		return false
+	}
+
+	mux.HandleEvent(newRequest)
+
+	return true
+}
+
+// maxSuggestions is the most commands which will be suggested for an unknown
+// command.
+const maxSuggestions = 3
+
+// suggestCommands replies with the commands closest to the given unknown
+// command if suggestions are enabled.
+func (m *CommandMux) suggestCommands(r *Request, prefix, cmd string) {
+	m.lock.RLock()
+
+	if !m.suggest || cmd == "" {
+		m.lock.RUnlock()
+		return
 	}
+
+	var names []string
+	for k := range m.cmdHelp {
+		names = internal.AppendStr(names, strings.SplitN(k, " ", 2)[0])
+	}
+
+	for k := range m.aliases {
+		names = internal.AppendStr(names, strings.SplitN(k, " ", 2)[0])
+	}
+
+	m.lock.RUnlock()
+
+	// Allow roughly one typo for every 3 characters.
+	maxDistance := len(cmd) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	distances := make(map[string]int)
+
+	var suggestions []string
+
+	for _, name := range names {
+		if d := internal.Levenshtein(cmd, name); d <= maxDistance {
+			distances[name] = d
+			suggestions = append(suggestions, name)
+		}
+	}
+
+	if len(suggestions) == 0 {
+		return
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+
+		return a < b
+	})
+
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+
+	for i := range suggestions {
+		suggestions[i] = prefix + suggestions[i]
+	}
+
+	r.MentionReplyf("Unknown command %q. Did you mean: %s?", prefix+cmd, strings.Join(suggestions, ", "))
 }
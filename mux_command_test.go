@@ -3,6 +3,7 @@ package seabird_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	irc "gopkg.in/irc.v3"
@@ -81,3 +82,282 @@ func TestCommandMux(t *testing.T) {
 	assert.Equal(t, 1, mh.count)
 	assert.Equal(t, 1, mh2.count)
 }
+
+func panicHandler(r *seabird.Request) {
+	panic("boom")
+}
+
+func TestCommandMuxPanic(t *testing.T) {
+	b := newTestBot(t, `
+panicreply = "Something went wrong"
+`, plugin("test/panic", func(b *seabird.Bot) error {
+		b.CommandMux().Event("panic", panicHandler, nil)
+		return nil
+	}))
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!panic",
+		":belak!~belak@host PRIVMSG #chan :!panic",
+	)
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :belak: Something went wrong",
+		"PRIVMSG #chan :belak: Something went wrong",
+	)
+	assert.Equal(t, map[string]int{
+		"github.com/belak/go-seabird_test.panicHandler": 2,
+	}, b.HandlerPanics())
+}
+
+func TestCommandMuxSubcommands(t *testing.T) {
+	b := newTestBot(t, "", plugin("test/subcommands", func(b *seabird.Bot) error {
+		cm := b.CommandMux()
+
+		cm.Event("karma top", func(r *seabird.Request) {
+			r.Replyf("top %d", r.Args().Int("count"))
+		}, &seabird.HelpInfo{
+			Description: "Shows the top karma",
+			Args:        []seabird.Arg{{Name: "count", Type: seabird.ArgInt, Optional: true}},
+		})
+		cm.Event("karma reset", func(r *seabird.Request) {
+			r.Replyf("reset %s", r.Message.Trailing())
+		}, &seabird.HelpInfo{
+			Usage:       "<nick>",
+			Description: "Resets someone's karma",
+		})
+		cm.Event("karma show", func(r *seabird.Request) {
+			r.Replyf("show")
+		}, nil)
+
+		return nil
+	}))
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!karma top 5",
+		":belak!~belak@host PRIVMSG #chan :!KARMA  Reset  belak",
+		":belak!~belak@host PRIVMSG #chan :!karma show",
+		":belak!~belak@host PRIVMSG #chan :!karma",
+		":belak!~belak@host PRIVMSG #chan :!karma bogus",
+		":belak!~belak@host PRIVMSG #chan :!help",
+		":belak!~belak@host PRIVMSG #chan :!help karma",
+		":belak!~belak@host PRIVMSG #chan :!help karma top",
+	)
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :top 5",
+		"PRIVMSG #chan :reset belak",
+		"PRIVMSG #chan show",
+		"PRIVMSG #chan :belak: Usage: !karma <reset|show|top>",
+		"PRIVMSG #chan :belak: Usage: !karma <reset|show|top>",
+		"PRIVMSG #chan :Available commands: help, karma. Use !help [command] for more info.",
+		"PRIVMSG #chan :Subcommands: reset, show, top. Use !help karma [subcommand] for more info.",
+		"PRIVMSG #chan :Usage: !karma top [count]",
+		"PRIVMSG #chan :Shows the top karma",
+	)
+}
+
+// aliasesPlugin registers a weather command with a couple of aliases.
+func aliasesPlugin() testPlugin {
+	return plugin("test/aliases", func(b *seabird.Bot) error {
+		b.CommandMux().Event("weather", func(r *seabird.Request) {
+			r.Replyf("weather %s", r.Message.Trailing())
+		}, &seabird.HelpInfo{
+			Usage:       "<location>",
+			Description: "Shows the weather",
+			Aliases:     []string{"w", "wx"},
+		})
+
+		return nil
+	})
+}
+
+func TestCommandMuxAliases(t *testing.T) {
+	b := newTestBot(t, `
+suggestcommands = true
+`, aliasesPlugin())
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!w here",
+		":belak!~belak@host PRIVMSG #chan :!WX there",
+		":belak!~belak@host PRIVMSG #chan :!help",
+		":belak!~belak@host PRIVMSG #chan :!help w",
+		":belak!~belak@host PRIVMSG #chan :!wether",
+		":belak!~belak@host PRIVMSG #chan :!nothingclose",
+		":belak!~belak@host PRIVMSG #chan :not a command",
+	)
+
+	// Only commands which are close to a real one get a suggestion.
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :weather here",
+		"PRIVMSG #chan :weather there",
+		"PRIVMSG #chan :Available commands: help, weather. Use !help [command] for more info.",
+		"PRIVMSG #chan :Usage: !weather <location>",
+		"PRIVMSG #chan :Shows the weather",
+		"PRIVMSG #chan :Aliases: w, wx",
+		`PRIVMSG #chan :belak: Unknown command "!wether". Did you mean: !weather?`,
+	)
+}
+
+func TestCommandMuxSuggestionsDisabled(t *testing.T) {
+	b := newTestBot(t, "", aliasesPlugin())
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!wether",
+	)
+
+	assertSentExactly(t, sent, "PRIVMSG")
+}
+
+func TestCommandMuxChannelPrefixes(t *testing.T) {
+	b := newTestBot(t, `
+mentionprefix = true
+
+[core.channelprefixes]
+"#other" = ["~", "."]
+"#quiet" = []
+`, aliasesPlugin())
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!weather chan",
+		":belak!~belak@host PRIVMSG #other :!weather ignored",
+		":belak!~belak@host PRIVMSG #other :~weather tilde",
+		":belak!~belak@host PRIVMSG #OTHER :.weather dot",
+		":belak!~belak@host PRIVMSG #other :bot: weather mention",
+		":belak!~belak@host PRIVMSG #quiet :!weather ignored",
+		":belak!~belak@host PRIVMSG #quiet :Bot, weather quiet",
+		":belak!~belak@host PRIVMSG #other :~help",
+		":belak!~belak@host PRIVMSG #quiet :bot: help weather",
+	)
+
+	// Each channel is handled separately, so only the replies are checked,
+	// not the order.
+	assertSentUnordered(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :weather chan",
+		"PRIVMSG #other :weather tilde",
+		"PRIVMSG #OTHER :weather dot",
+		"PRIVMSG #other :weather mention",
+		"PRIVMSG #quiet :weather quiet",
+		"PRIVMSG #other :Available commands: help, weather. Use ~help [command] for more info.",
+		"PRIVMSG #quiet :Usage: bot: weather <location>",
+		"PRIVMSG #quiet :Shows the weather",
+		"PRIVMSG #quiet :Aliases: w, wx",
+	)
+}
+
+func TestCommandMuxMentionPrefix(t *testing.T) {
+	b := newTestBot(t, `
+mentionprefix = true
+`, plugin("test/mentions", func(b *seabird.Bot) error {
+		b.CommandMux().Event("foo", func(r *seabird.Request) {
+			r.Replyf("command %s", r.Message.Trailing())
+		}, nil)
+		b.MentionMux().Event(func(r *seabird.Request) {
+			r.Replyf("mention %s", r.Message.Trailing())
+		})
+
+		return nil
+	}))
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :bot: foo bar",
+		":belak!~belak@host PRIVMSG #chan :bot: hello there",
+		":belak!~belak@host PRIVMSG #chan :!foo baz",
+	)
+
+	// A mention which runs a command shouldn't also go to the MentionMux.
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :command bar",
+		"PRIVMSG #chan :mention hello there",
+		"PRIVMSG #chan :command baz",
+	)
+}
+
+func TestCommandMuxRateLimits(t *testing.T) {
+	b := newTestBot(t, `
+admins = ["admin!*@admin.host"]
+
+[core.ratelimits.cheap]
+scope = "global"
+interval = "1h"
+`, plugin("test/ratelimit", func(b *seabird.Bot) error {
+		cm := b.CommandMux()
+
+		cm.Event("expensive", func(r *seabird.Request) {
+			r.Replyf("expensive for %s", r.Message.Prefix.Name)
+		}, &seabird.HelpInfo{
+			RateLimits: []seabird.RateLimit{
+				{Interval: time.Hour, Burst: 2, Notice: true},
+				{Scope: seabird.RateLimitChannel, Interval: time.Hour, Burst: 3},
+			},
+		})
+		cm.Event("cheap", func(r *seabird.Request) {
+			r.Replyf("cheap for %s", r.Message.Prefix.Name)
+		}, nil)
+
+		return nil
+	}))
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":a!~a@a.host PRIVMSG #chan :!expensive",
+		":a!~a@a.host PRIVMSG #chan :!expensive",
+		":a!~a@a.host PRIVMSG #chan :!expensive",
+		":a!~a@a.host PRIVMSG #chan :!expensive",
+		":a!~a@a.host PRIVMSG #chan :!expensive",
+		":b!~b@b.host PRIVMSG #chan :!expensive",
+		":c!~c@c.host PRIVMSG #chan :!expensive",
+		":c!~c@c.host PRIVMSG #other :!expensive",
+		":admin!~a@admin.host PRIVMSG #chan :!expensive",
+		":a!~a@a.host PRIVMSG #chan :!cheap",
+		":b!~b@b.host PRIVMSG #other :!cheap",
+	)
+
+	// The channel limit stops c in #chan, but not in #other, and admins skip
+	// the limits entirely.
+	assertSentUnordered(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :expensive for a",
+		"PRIVMSG #chan :expensive for a",
+		"PRIVMSG #chan :expensive for b",
+		"PRIVMSG #other :expensive for c",
+		"PRIVMSG #chan :expensive for admin",
+		"PRIVMSG #chan :cheap for a",
+	)
+
+	// Repeated calls should only be noticed once, and limits from the config
+	// don't send a notice unless asked to. Any limit asking for a notice
+	// means c is told about the channel limit too.
+	assertSentUnordered(t, sent, "NOTICE",
+		"NOTICE a :You're using !expensive too often. Try again in 1h0m0s.",
+		"NOTICE c :You're using !expensive too often. Try again in 1h0m0s.",
+	)
+}
+
+func TestCommandMuxCasemapping(t *testing.T) {
+	b := newTestBot(t, "", plugin("test/casemapping", func(b *seabird.Bot) error {
+		b.CommandMux().Event("Quote[", func(r *seabird.Request) {
+			r.Replyf("quoted")
+		}, nil)
+
+		return nil
+	}))
+
+	// Commands should be matched the same way they were registered, even if
+	// the server uses a different casemapping.
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		"005 bot CASEMAPPING=ascii :are supported by this server",
+		":belak!~belak@host PRIVMSG #chan :!quote[",
+		":belak!~belak@host PRIVMSG #chan :!QUOTE[",
+	)
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan quoted",
+		"PRIVMSG #chan quoted",
+	)
+}
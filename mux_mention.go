@@ -12,24 +12,70 @@ import (
 // Client has been mentioned. The nick, punctuation and any leading or
 // trailing spaces are removed from the message.
 type MentionMux struct {
-	handlers []HandlerFunc
-	lock     *sync.RWMutex
+	handlers   []*muxEntry
+	uses       []*middlewareGroup
+	middleware []Middleware
+	lock       *sync.RWMutex
+	onRegister func(*Registration)
 }
 
 // NewMentionMux will create an initialized MentionMux with no handlers.
 func NewMentionMux() *MentionMux {
 	return &MentionMux{
+		nil,
+		nil,
 		nil,
 		&sync.RWMutex{},
+		nil,
 	}
 }
 
-// Event will register a Handler.
-func (m *MentionMux) Event(h HandlerFunc) {
+// Use adds middleware which will be applied to every handler in this mux. The
+// returned Registration can be used to remove the middleware.
+func (m *MentionMux) Use(middleware ...Middleware) *Registration {
+	group := &middlewareGroup{middleware}
+
+	m.lock.Lock()
+	m.uses = append(m.uses, group)
+	m.middleware = flattenMiddleware(m.uses)
+	m.lock.Unlock()
+
+	reg := newRegistration(func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		m.uses = removeMiddleware(m.uses, group)
+		m.middleware = flattenMiddleware(m.uses)
+	})
+
+	if m.onRegister != nil {
+		m.onRegister(reg)
+	}
+
+	return reg
+}
+
+// Event will register a Handler. Any middleware passed in will only apply to
+// this handler. The returned Registration can be used to remove the handler.
+func (m *MentionMux) Event(h HandlerFunc, middleware ...Middleware) *Registration {
+	entry := newMuxEntry(h, middleware)
+
 	m.lock.Lock()
-	defer m.lock.Unlock()
+	m.handlers = append(m.handlers, entry)
+	m.lock.Unlock()
 
-	m.handlers = append(m.handlers, h)
+	reg := newRegistration(func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		m.handlers = removeEntry(m.handlers, entry)
+	})
+
+	if m.onRegister != nil {
+		m.onRegister(reg)
+	}
+
+	return reg
 }
 
 // HandleEvent strips off the nick punctuation and spaces and runs the handlers.
@@ -39,28 +85,37 @@ func (m *MentionMux) HandleEvent(r *Request) {
 		return
 	}
 
-	lastArg := r.Message.Trailing()
-	nick := r.CurrentNick()
-
-	// We only handle this event if it starts with the
-	// current bot's nick followed by punctuation
-	if len(lastArg) < len(nick)+2 ||
-		!strings.HasPrefix(lastArg, nick) ||
-		!unicode.IsPunct(rune(lastArg[len(nick)])) ||
-		lastArg[len(nick)+1] != ' ' {
+	msg, ok := stripMention(r, r.Message.Trailing())
+	if !ok {
 		return
 	}
 
 	// Copy it into a new Event
 	newRequest := r.Copy()
-
-	// Strip the nick, punctuation, and spaces from the message
-	newRequest.Message.Params[len(newRequest.Message.Params)-1] = strings.TrimSpace(lastArg[len(nick)+1:])
+	newRequest.Message.Params[len(newRequest.Message.Params)-1] = msg
 
 	m.lock.RLock()
-	defer m.lock.RUnlock()
+	middleware := m.middleware
+	handlers := m.handlers
+	m.lock.RUnlock()
 
-	for _, h := range m.handlers {
-		h(newRequest)
+	for _, h := range handlers {
+		h.call(newRequest, middleware, true)
 	}
 }
+
+// stripMention checks if the message starts with the current bot's nick
+// followed by punctuation and a space. If it does, the nick, punctuation and
+// spaces are stripped from the message.
+func stripMention(r *Request, msg string) (string, bool) {
+	nick := r.CurrentNick()
+
+	if len(msg) < len(nick)+2 ||
+		!r.ISupport().EqualFold(msg[:len(nick)], nick) ||
+		!unicode.IsPunct(rune(msg[len(nick)])) ||
+		msg[len(nick)+1] != ' ' {
+		return "", false
+	}
+
+	return strings.TrimSpace(msg[len(nick)+1:]), true
+}
@@ -0,0 +1,192 @@
+package seabird
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gobwas/glob"
+	irc "gopkg.in/irc.v3"
+)
+
+// PermissionAdmin is the permission required for the built in admin commands.
+const PermissionAdmin = "admin"
+
+// roleConfig is a role from the core config. Anyone matching the role is given
+// its permissions.
+type roleConfig struct {
+	// Accounts are services account names. They are checked against the
+	// account-tag on the message, falling back to what the Tracker knows.
+	Accounts []string
+
+	// Hostmasks are globs like *!*@example.com.
+	Hostmasks []string
+
+	// ChannelOps matches anyone who is an op in the channel a command was
+	// sent to. It never matches private messages.
+	ChannelOps bool
+
+	// Permissions are globs using . as a separator, so "karma.*" would
+	// include "karma.reset".
+	Permissions []string
+
+	// The compiled Hostmasks and Permissions. These are filled in by validate
+	// so they don't need to be compiled for every check.
+	hostmasks   []*regexp.Regexp
+	permissions []glob.Glob
+}
+
+// validate makes sure all the hostmasks and permissions can be compiled and
+// stores the compiled versions.
+func (c *roleConfig) validate(name string) error {
+	if len(c.Accounts) == 0 && len(c.Hostmasks) == 0 && !c.ChannelOps {
+		return newConfigError("core", "roles."+name, "must match by accounts, hostmasks or channelops")
+	}
+
+	var err error
+
+	c.hostmasks, err = compileMasks(c.Hostmasks)
+	if err != nil {
+		return newConfigError("core", "roles."+name+".hostmasks", "%s", err)
+	}
+
+	c.permissions, err = compileGlobs(c.Permissions)
+	if err != nil {
+		return newConfigError("core", "roles."+name+".permissions", "%s", err)
+	}
+
+	return nil
+}
+
+// adminRole returns the role built from the admins option, which has every
+// permission.
+func adminRole(admins []string) (roleConfig, error) {
+	hostmasks, err := compileMasks(admins)
+	if err != nil {
+		return roleConfig{}, newConfigError("core", "admins", "%s", err)
+	}
+
+	return roleConfig{
+		Hostmasks:   admins,
+		Permissions: []string{"**"},
+		hostmasks:   hostmasks,
+		permissions: []glob.Glob{glob.MustCompile("**", '.')},
+	}, nil
+}
+
+// compileMasks converts hostmasks like *!*@example.com to regular expressions.
+func compileMasks(masks []string) ([]*regexp.Regexp, error) {
+	var ret []*regexp.Regexp
+
+	for _, mask := range masks {
+		re, err := irc.MaskToRegex(mask)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hostmask %q", mask)
+		}
+
+		ret = append(ret, re)
+	}
+
+	return ret, nil
+}
+
+// matches checks if the sender of the request matches this role.
+func (c *roleConfig) matches(r *Request) bool {
+	// Op status only counts in the channel the command was sent to, so this
+	// never matches private messages.
+	if c.ChannelOps && r.FromChannel() && r.Message.Prefix != nil {
+		if t := r.Tracker(); t != nil && t.IsOp(r.Message.Params[0], r.Message.Prefix.Name) {
+			return true
+		}
+	}
+
+	if account := senderAccount(r); account != "" {
+		for _, name := range c.Accounts {
+			if r.ISupport().EqualFold(name, account) {
+				return true
+			}
+		}
+	}
+
+	// The user and host are needed to make sure someone can't match a mask
+	// just by picking the right nick.
+	if r.Message.Prefix == nil || r.Message.Prefix.User == "" || r.Message.Prefix.Host == "" {
+		return false
+	}
+
+	prefix := r.Message.Prefix.String()
+
+	for _, re := range c.hostmasks {
+		if re.MatchString(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// grants checks if this role includes the given permission.
+func (c *roleConfig) grants(permission string) bool {
+	return matchesGloblist(permission, c.permissions)
+}
+
+// senderAccount returns the services account of the sender of a request, or an
+// empty string if they aren't logged in or it isn't known.
+func senderAccount(r *Request) string {
+	if account, ok := r.Message.Tags.GetTag("account"); ok {
+		return account
+	}
+
+	if r.Message.Prefix == nil {
+		return ""
+	}
+
+	if t := r.Tracker(); t != nil {
+		if u, ok := t.User(r.Message.Prefix.Name); ok {
+			return u.Account
+		}
+	}
+
+	return ""
+}
+
+// roles returns all the roles from the config, including one built from the
+// admins option which has every permission.
+func (b *Bot) roles() []roleConfig {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+
+	return b.config.roles
+}
+
+// HasPermission returns true if the sender of this request matches any role
+// which has the given permission.
+func (r *Request) HasPermission(permission string) bool {
+	if r.bot == nil {
+		return false
+	}
+
+	for _, role := range r.bot.roles() {
+		if role.grants(permission) && role.matches(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequirePermission is a Middleware which only allows requests through if the
+// sender has the given permission. Anyone else is told permission was denied.
+func RequirePermission(permission string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *Request) {
+			if !r.HasPermission(permission) {
+				r.GetLogger("permissions").Infof("Denied %s permission to %s", permission, r.Message.Prefix)
+				r.MentionReplyf("Permission denied")
+
+				return
+			}
+
+			next(r)
+		}
+	}
+}
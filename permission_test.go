@@ -0,0 +1,105 @@
+package seabird_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/belak/go-seabird"
+)
+
+// permissionsPlugin registers a command which needs a permission and one
+// which reports whether the user has another.
+func permissionsPlugin() testPlugin {
+	return plugin("test/permissions", func(b *seabird.Bot) error {
+		cm := b.CommandMux()
+
+		cm.Event("reset", func(r *seabird.Request) {
+			r.Replyf("reset by %s", r.Message.Prefix.Name)
+		}, &seabird.HelpInfo{
+			Description: "Resets everything",
+			Permission:  "karma.reset",
+		})
+		cm.Event("check", func(r *seabird.Request) {
+			r.Replyf("%s can kick: %t", r.Message.Prefix.Name, r.HasPermission("chan.kick"))
+		}, nil)
+
+		return nil
+	})
+}
+
+const permissionTestConfig = `
+admins = ["admin!*@admin.host"]
+admincommands = true
+
+[core.roles.karma]
+accounts = ["karmauser"]
+hostmasks = ["*!*@karma.host"]
+permissions = ["karma.*"]
+
+[core.roles.ops]
+channelops = true
+permissions = ["chan.kick"]
+`
+
+func TestPermissions(t *testing.T) {
+	b := newTestBot(t, permissionTestConfig, permissionsPlugin())
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":bot!~bot@bot.host JOIN #chan",
+		"353 bot = #chan :bot @op tracked",
+		":bot!~bot@bot.host JOIN #other",
+		"353 bot = #other :bot op",
+		":tracked!~t@other.host ACCOUNT karmauser",
+		":nobody!~n@nobody.host PRIVMSG #chan :!reset",
+		":karma!~k@karma.host PRIVMSG #chan :!reset",
+		"@account=karmauser :tagged!~t@other.host PRIVMSG #chan :!reset",
+		":tracked!~t@other.host PRIVMSG #chan :!reset",
+		":admin!~a@admin.host PRIVMSG #chan :!reset",
+		":karma!~k@karma.host PRIVMSG #chan :!plugin list",
+		":op!~o@op.host PRIVMSG #chan :!check",
+		":op!~o@op.host PRIVMSG bot :!check",
+		":op!~o@op.host PRIVMSG #other :!check",
+		":nobody!~n@nobody.host PRIVMSG #chan :!check",
+		":nobody!~n@nobody.host PRIVMSG #chan :!help reset",
+	)
+
+	// op only gets chan.kick in #chan, where they are actually an op.
+	assertSentUnordered(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :nobody: Permission denied",
+		"PRIVMSG #chan :reset by karma",
+		"PRIVMSG #chan :reset by tagged",
+		"PRIVMSG #chan :reset by tracked",
+		"PRIVMSG #chan :reset by admin",
+		"PRIVMSG #chan :karma: Permission denied",
+		"PRIVMSG #chan :op can kick: true",
+		"PRIVMSG op :op can kick: false",
+		"PRIVMSG #other :op can kick: false",
+		"PRIVMSG #chan :nobody can kick: false",
+		"PRIVMSG #chan :Resets everything",
+		"PRIVMSG #chan :Requires the karma.reset permission",
+	)
+}
+
+func TestPermissionsWHOX(t *testing.T) {
+	b := newTestBot(t, permissionTestConfig, permissionsPlugin())
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		"005 bot WHOX :are supported by this server",
+		":bot!~bot@bot.host JOIN #chan",
+		"353 bot = #chan :bot existing",
+		"354 bot 913 ~e existing.host existing karmauser",
+		":existing!~e@existing.host PRIVMSG #chan :!reset",
+	)
+
+	assertSentExactly(t, sent, "WHO", "WHO #chan %tuhna,913")
+	assertSentExactly(t, sent, "PRIVMSG", "PRIVMSG #chan :reset by existing")
+
+	user, ok := b.Tracker().User("existing")
+	require.True(t, ok)
+	assert.Equal(t, "karmauser", user.Account)
+	assert.Equal(t, "existing.host", user.Host)
+}
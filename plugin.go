@@ -1,7 +1,10 @@
 package seabird
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/gobwas/glob"
 
@@ -10,47 +13,261 @@ import (
 
 type PluginFactory func(b *Bot) error
 
-var plugins = make(map[string]PluginFactory)
+// PluginInfo contains optional metadata about a plugin.
+type PluginInfo struct {
+	Description string
+	Version     string
+
+	// Dependencies are plugins which must be loaded before this one. It is an
+	// error to enable this plugin without them.
+	Dependencies []string
+
+	// OptionalDependencies are plugins which will be loaded before this one
+	// if they're enabled.
+	OptionalDependencies []string
+
+	// Validate checks the plugin's config without any side effects. If it is
+	// set, CheckConfig calls it instead of the PluginFactory, so it should
+	// only read the config with Bot.Config and never connect to anything or
+	// register handlers.
+	Validate PluginFactory
+}
+
+// PluginError is returned when a plugin fails to load. If it failed because
+// one of its dependencies failed, Err will wrap the PluginError for that
+// dependency.
+type PluginError struct {
+	Plugin string
+	Err    error
+}
+
+func (e *PluginError) Error() string {
+	path := e.Path()
+
+	// Find the error which started it all.
+	cause := e
+	for next := cause; errors.As(next.Err, &next); {
+		cause = next
+	}
+
+	return fmt.Sprintf("Plugin %s failed to load: %s", strings.Join(path, " -> "), cause.Err)
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+// Path returns the chain of plugins which failed, starting with this one and
+// ending with the one which caused the failure.
+func (e *PluginError) Path() []string {
+	ret := []string{e.Plugin}
+
+	for next := e; errors.As(next.Err, &next); {
+		ret = append(ret, next.Plugin)
+	}
+
+	return ret
+}
+
+// pluginRegistry contains all the plugins a bot can load.
+type pluginRegistry struct {
+	factories map[string]PluginFactory
+	info      map[string]PluginInfo
+}
+
+func newPluginRegistry() *pluginRegistry {
+	return &pluginRegistry{
+		factories: make(map[string]PluginFactory),
+		info:      make(map[string]PluginInfo),
+	}
+}
+
+// plugins is the registry RegisterPlugin adds to and every Bot uses.
+var plugins = newPluginRegistry()
 
 // RegisterPlugin registers a PluginFactory for a given name. It will
 // panic if multiple plugins are registered with the same name.
 func RegisterPlugin(name string, factory PluginFactory) {
-	if _, ok := plugins[name]; ok {
+	RegisterPluginWithInfo(name, factory, PluginInfo{})
+}
+
+// RegisterPluginWithInfo registers a PluginFactory along with metadata about
+// the plugin. It will panic if multiple plugins are registered with the same
+// name.
+func RegisterPluginWithInfo(name string, factory PluginFactory, info PluginInfo) {
+	plugins.register(name, factory, info)
+}
+
+// GetPluginInfo returns the metadata for a registered plugin.
+func GetPluginInfo(name string) (PluginInfo, bool) {
+	info, ok := plugins.info[name]
+	return info, ok
+}
+
+func (r *pluginRegistry) register(name string, factory PluginFactory, info PluginInfo) {
+	if _, ok := r.factories[name]; ok {
 		panic(fmt.Sprintf("Plugin %q registered multiple times", name))
 	}
 
-	plugins[name] = factory
+	r.factories[name] = factory
+	r.info[name] = info
 }
 
-func matchingPlugins(rawWhitelist []string) ([]string, error) {
-	var whitelist []glob.Glob
+// sort orders the given plugins so every plugin comes after its
+// dependencies. Plugins with no ordering constraints between them are sorted
+// by name so the load order is the same every time. An error is returned if a
+// required dependency isn't enabled or there is a dependency cycle.
+func (r *pluginRegistry) sort(names []string) ([]string, error) {
+	enabled := make(map[string]bool)
+	for _, name := range names {
+		enabled[name] = true
+	}
+
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
 
-	// Compile all of the whitelist into globs
-	for _, rawGlob := range rawWhitelist {
-		g, err := glob.Compile(rawGlob, '.')
+	var (
+		ret   []string
+		done  = make(map[string]bool)
+		stack []string
+	)
+
+	var visit func(name string) error
+
+	visit = func(name string) error {
+		if done[name] {
+			return nil
+		}
+
+		for i, other := range stack {
+			if other == name {
+				cycle := append(append([]string{}, stack[i:]...), name)
+				return fmt.Errorf("Plugin dependency cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		stack = append(stack, name)
+
+		for _, dep := range r.dependencies(name, enabled) {
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		done[name] = true
+		ret = append(ret, name)
+
+		return nil
+	}
+
+	for _, name := range sorted {
+		for _, dep := range r.info[name].Dependencies {
+			if _, ok := r.factories[dep]; !ok {
+				return nil, fmt.Errorf("Plugin %q depends on %q which does not exist", name, dep)
+			}
+
+			if !enabled[dep] {
+				return nil, fmt.Errorf("Plugin %q depends on %q which is not enabled", name, dep)
+			}
+		}
+	}
+
+	for _, name := range sorted {
+		err := visit(name)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		whitelist = append(whitelist, g)
+	return ret, nil
+}
+
+// dependencies returns the declared dependencies of a plugin which are
+// enabled, in sorted order.
+func (r *pluginRegistry) dependencies(name string, enabled map[string]bool) []string {
+	info := r.info[name]
+
+	var ret []string
+
+	for _, dep := range info.Dependencies {
+		ret = internal.AppendStr(ret, dep)
+	}
+
+	for _, dep := range info.OptionalDependencies {
+		if enabled[dep] {
+			ret = internal.AppendStr(ret, dep)
+		}
 	}
 
+	sort.Strings(ret)
+
+	return ret
+}
+
+// matching returns all plugins which match any of the globs in the whitelist
+// and none of the globs in the blacklist. Entries in the whitelist starting
+// with a ! are treated as part of the blacklist. If there are no other entries
+// in the whitelist, all plugins will match it.
+func (r *pluginRegistry) matching(rawWhitelist, rawBlacklist []string) ([]string, error) {
+	var positive, negative []string
+
+	for _, rawGlob := range rawWhitelist {
+		if strings.HasPrefix(rawGlob, "!") {
+			negative = append(negative, rawGlob[1:])
+		} else {
+			positive = append(positive, rawGlob)
+		}
+	}
+
+	negative = append(negative, rawBlacklist...)
+
 	// If the whitelist is empty, we want to match all plugins.
-	if len(rawWhitelist) == 0 {
-		whitelist = append(whitelist, glob.MustCompile("**", '.'))
+	if len(positive) == 0 {
+		positive = append(positive, "**")
+	}
+
+	whitelist, err := compileGlobs(positive)
+	if err != nil {
+		return nil, err
+	}
+
+	blacklist, err := compileGlobs(negative)
+	if err != nil {
+		return nil, err
 	}
 
 	var matching []string
 
-	for item := range plugins {
-		if matchesGloblist(item, whitelist) {
+	for item := range r.factories {
+		if matchesGloblist(item, whitelist) && !matchesGloblist(item, blacklist) {
 			matching = internal.AppendStr(matching, item)
 		}
 	}
 
+	sort.Strings(matching)
+
 	return matching, nil
 }
 
+// compileGlobs compiles all of the given strings into globs.
+func compileGlobs(rawGlobs []string) ([]glob.Glob, error) {
+	var ret []glob.Glob
+
+	for _, rawGlob := range rawGlobs {
+		g, err := glob.Compile(rawGlob, '.')
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, g)
+	}
+
+	return ret, nil
+}
+
 // matchesGloblist is a simple function which tries an item against a
 // slice of globs. It returns true if any of them match.
 func matchesGloblist(item string, list []glob.Glob) bool {
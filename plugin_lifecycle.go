@@ -0,0 +1,236 @@
+package seabird
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/belak/go-seabird/internal"
+)
+
+// pluginState tracks everything a loaded plugin has set up so it can be torn
+// down again.
+type pluginState struct {
+	regs         *RegistrationGroup
+	teardown     []func()
+	reload       []configReloadHook
+	dependencies []string
+}
+
+func newPluginState() *pluginState {
+	return &pluginState{
+		regs: NewRegistrationGroup(),
+	}
+}
+
+// currentPlugin returns the name of the plugin which is currently being loaded
+// or an empty string if no plugin is being loaded.
+func (b *Bot) currentPlugin() string {
+	b.pluginLock.Lock()
+	defer b.pluginLock.Unlock()
+
+	if len(b.loadingContext) == 0 {
+		return ""
+	}
+
+	return b.loadingContext[len(b.loadingContext)-1]
+}
+
+// currentPluginState returns the state of the plugin currently being loaded
+// or nil if no plugin is being loaded.
+func (b *Bot) currentPluginState() *pluginState {
+	name := b.currentPlugin()
+	if name == "" {
+		return nil
+	}
+
+	b.pluginLock.Lock()
+	defer b.pluginLock.Unlock()
+
+	return b.pluginStates[name]
+}
+
+// trackRegistration is hooked into all the muxes and CapRequest so any
+// handlers, middleware and cap requests registered while a plugin is loading
+// are removed when that plugin is unloaded.
+func (b *Bot) trackRegistration(reg *Registration) {
+	if state := b.currentPluginState(); state != nil {
+		state.regs.Add(reg)
+	}
+}
+
+// OnUnload registers a function which will be called when the plugin
+// currently being loaded is unloaded. This should be called from a
+// PluginFactory and is where a plugin should stop any goroutines it started.
+// Any handlers, middleware and cap requests the plugin registered while loading
+// are removed automatically.
+func (b *Bot) OnUnload(f func()) {
+	state := b.currentPluginState()
+	if state == nil {
+		b.log.Warn("OnUnload called outside of a PluginFactory")
+		return
+	}
+
+	state.teardown = append(state.teardown, f)
+}
+
+// LoadedPlugins returns the names of all currently loaded plugins.
+func (b *Bot) LoadedPlugins() []string {
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	return b.loadedPluginNames()
+}
+
+// loadedPluginNames is LoadedPlugins for callers which already hold the
+// lifecycleLock.
+func (b *Bot) loadedPluginNames() []string {
+	var ret []string
+
+	for name, loaded := range b.loadedPlugins {
+		if loaded {
+			ret = append(ret, name)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// FailedPlugins returns the error for every plugin which failed to load and
+// hasn't been loaded since.
+func (b *Bot) FailedPlugins() map[string]error {
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	ret := make(map[string]error, len(b.failedPlugins))
+	for name, err := range b.failedPlugins {
+		ret[name] = err
+	}
+
+	return ret
+}
+
+// LoadPlugin loads a registered plugin which is not currently loaded. This
+// can be used to enable a plugin at runtime, even if it wasn't enabled in the
+// config.
+func (b *Bot) LoadPlugin(name string) error {
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	return b.enablePlugin(name)
+}
+
+// UnloadPlugin removes all handlers, middleware and cap requests a plugin
+// registered while loading and calls any functions it registered with
+// OnUnload. It is an error to unload a plugin which other loaded plugins
+// depend on.
+func (b *Bot) UnloadPlugin(name string) error {
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	return b.disablePlugin(name)
+}
+
+// ReloadPlugin unloads a plugin and loads it again so it picks up any config
+// changes. The IRC connection is not affected.
+func (b *Bot) ReloadPlugin(name string) error {
+	b.lifecycleLock.Lock()
+	defer b.lifecycleLock.Unlock()
+
+	err := b.disablePlugin(name)
+	if err != nil {
+		return err
+	}
+
+	return b.enablePlugin(name)
+}
+
+func (b *Bot) enablePlugin(name string) error {
+	if _, ok := b.plugins.factories[name]; !ok {
+		return fmt.Errorf("Plugin %q does not exist", name)
+	}
+
+	if b.loadedPlugins[name] {
+		return fmt.Errorf("Plugin %q already loaded", name)
+	}
+
+	// Give plugins which failed before another chance.
+	delete(b.failedPlugins, name)
+
+	return b.loadPlugin(name)
+}
+
+func (b *Bot) disablePlugin(name string) error {
+	if !b.loadedPlugins[name] {
+		return fmt.Errorf("Plugin %q not loaded", name)
+	}
+
+	if dependents := b.pluginDependents(name); len(dependents) > 0 {
+		return fmt.Errorf(
+			"Plugin %q is required by: %s",
+			name, strings.Join(dependents, ", "))
+	}
+
+	b.teardownPlugin(name)
+
+	return nil
+}
+
+// teardownPlugin removes everything the plugin set up and marks it as not
+// loaded.
+func (b *Bot) teardownPlugin(name string) {
+	b.pluginLock.Lock()
+	state := b.pluginStates[name]
+	delete(b.pluginStates, name)
+	b.pluginLock.Unlock()
+
+	b.loadedPlugins[name] = false
+
+	if state == nil {
+		return
+	}
+
+	state.regs.Remove()
+
+	for _, f := range state.teardown {
+		f := f
+		_ = b.callPluginHook(name, func() error {
+			f()
+			return nil
+		})
+	}
+}
+
+// callPluginHook calls a function registered by a plugin, making sure a panic
+// doesn't take down the bot.
+func (b *Bot) callPluginHook(name string, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.log.WithField("plugin", name).Errorf("Recovered from panic: %v", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return f()
+}
+
+// pluginDependents returns all loaded plugins which called EnsurePlugin for
+// the given plugin.
+func (b *Bot) pluginDependents(name string) []string {
+	b.pluginLock.Lock()
+	defer b.pluginLock.Unlock()
+
+	var ret []string
+
+	for other, state := range b.pluginStates {
+		if b.loadedPlugins[other] && internal.IsSliceContainsStr(state.dependencies, name) {
+			ret = append(ret, other)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
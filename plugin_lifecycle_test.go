@@ -0,0 +1,173 @@
+package seabird_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seabird "github.com/belak/go-seabird"
+)
+
+// reloadPlugin registers a ping command and counts how many times it was
+// unloaded.
+func reloadPlugin(teardowns *int) testPlugin {
+	return plugin("test/reload", func(b *seabird.Bot) error {
+		b.CommandMux().Event("ping", func(r *seabird.Request) {
+			r.Replyf("pong")
+		}, nil)
+
+		b.OnUnload(func() {
+			*teardowns++
+		})
+
+		return nil
+	})
+}
+
+// reloadDepPlugin depends on reloadPlugin.
+func reloadDepPlugin() testPlugin {
+	return plugin("test/reload-dep", func(b *seabird.Bot) error {
+		return b.EnsurePlugin("test/reload")
+	})
+}
+
+func TestPluginReload(t *testing.T) {
+	var teardowns int
+
+	b := newTestBot(t, `
+plugins = ["test/reload"]
+admins = ["belak!*@*"]
+admincommands = true
+`, reloadPlugin(&teardowns))
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!ping",
+		":other!~other@host PRIVMSG #chan :!plugin unload test/reload",
+		":belak!~belak@host PRIVMSG #chan :!plugin unload test/reload",
+		":belak!~belak@host PRIVMSG #chan :!ping",
+		":belak!~belak@host PRIVMSG #chan :!plugin load test/reload",
+		":belak!~belak@host PRIVMSG #chan :!plugin reload test/reload",
+		":belak!~belak@host PRIVMSG #chan :!ping",
+	)
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :pong",
+		"PRIVMSG #chan :other: Permission denied",
+		"PRIVMSG #chan :belak: Unloaded plugin test/reload",
+		"PRIVMSG #chan :belak: Loaded plugin test/reload",
+		"PRIVMSG #chan :belak: Reloaded plugin test/reload",
+		"PRIVMSG #chan :pong",
+	)
+	assert.Equal(t, 2, teardowns)
+	assert.Equal(t, []string{"test/reload"}, b.LoadedPlugins())
+}
+
+func TestAdminCommandsDisabled(t *testing.T) {
+	b := newTestBot(t, `
+admins = ["belak!*@*"]
+`, plugin("test/rehash", func(b *seabird.Bot) error {
+		b.CommandMux().Event("rehash", func(r *seabird.Request) {
+			r.Replyf("plugin rehash")
+		}, nil)
+
+		return nil
+	}))
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!rehash",
+		":belak!~belak@host PRIVMSG #chan :!plugin list",
+	)
+
+	// Without admincommands, plugins are free to use the reserved names and
+	// the plugin command doesn't exist.
+	assertSentExactly(t, sent, "PRIVMSG", "PRIVMSG #chan :plugin rehash")
+}
+
+func TestPluginUnloadDependency(t *testing.T) {
+	var teardowns int
+
+	b := newTestBot(t, "", reloadPlugin(&teardowns), reloadDepPlugin())
+
+	runTestBot(t, b)
+
+	assert.Error(t, b.UnloadPlugin("test/reload"))
+	assert.NoError(t, b.UnloadPlugin("test/reload-dep"))
+	assert.NoError(t, b.UnloadPlugin("test/reload"))
+	assert.Error(t, b.UnloadPlugin("test/reload"))
+	assert.Empty(t, b.LoadedPlugins())
+	assert.Equal(t, 1, teardowns)
+}
+
+func TestPluginReloadMiddleware(t *testing.T) {
+	var calls []string
+
+	b := newTestBot(t, "", plugin("test/reload-middleware", func(b *seabird.Bot) error {
+		// The BasicMux middleware runs for every handler, so it only records
+		// the TEST messages to keep this independent of the core handlers.
+		count := func(name, command string) seabird.Middleware {
+			return func(next seabird.HandlerFunc) seabird.HandlerFunc {
+				return func(r *seabird.Request) {
+					if command == "" || r.Message.Command == command {
+						calls = append(calls, name)
+					}
+					next(r)
+				}
+			}
+		}
+
+		b.BasicMux().Use(count("basic", "TEST"))
+		b.CommandMux().Use(count("command", ""))
+		b.MentionMux().Use(count("mention", ""))
+		b.CapRequest("away-notify", false)
+
+		b.BasicMux().Event("TEST", func(r *seabird.Request) {})
+		b.CommandMux().Event("ping", func(r *seabird.Request) {}, nil)
+		b.MentionMux().Event(func(r *seabird.Request) {})
+
+		return nil
+	}))
+
+	runTestBot(t, b)
+
+	// Reloading shouldn't stack another copy of the middleware.
+	require.NoError(t, b.ReloadPlugin("test/reload-middleware"))
+	require.NoError(t, b.ReloadPlugin("test/reload-middleware"))
+
+	calls = nil
+
+	sent := runTestBot(t, b,
+		"CAP * LS :away-notify",
+		"CAP * ACK :away-notify",
+		"001 bot :Welcome",
+		":server TEST",
+		":belak!~belak@host PRIVMSG #chan :!ping",
+		":belak!~belak@host PRIVMSG #chan :bot: hello",
+	)
+
+	assertSent(t, sent, "CAP REQ :away-notify")
+
+	// The BasicMux middleware runs for the tracker's "*" handler as well as
+	// the TEST handler.
+	assert.Equal(t, []string{"basic", "basic", "command", "mention"}, calls)
+
+	// Once unloaded, the middleware and cap request should be gone.
+	require.NoError(t, b.UnloadPlugin("test/reload-middleware"))
+
+	calls = nil
+
+	sent = runTestBot(t, b,
+		"CAP * LS :away-notify",
+		"001 bot :Welcome",
+		":server TEST",
+		":belak!~belak@host PRIVMSG #chan :!ping",
+		":belak!~belak@host PRIVMSG #chan :bot: hello",
+	)
+
+	for _, m := range filterSent(sent, "CAP") {
+		assert.NotEqual(t, "REQ", m.Params[0], "unexpected %s", m)
+	}
+	assert.Empty(t, calls)
+}
@@ -0,0 +1,211 @@
+package seabird_test
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seabird "github.com/belak/go-seabird"
+)
+
+// orderPlugins returns plugins with dependencies between them which record
+// the order they were loaded in.
+func orderPlugins(loadOrder *[]string) []testPlugin {
+	orderPlugin := func(name string, info seabird.PluginInfo) testPlugin {
+		return testPlugin{name, func(b *seabird.Bot) error {
+			*loadOrder = append(*loadOrder, name)
+			return nil
+		}, info}
+	}
+
+	return []testPlugin{
+		orderPlugin("test/order-a", seabird.PluginInfo{
+			Description:  "Depends on c",
+			Dependencies: []string{"test/order-c"},
+		}),
+		orderPlugin("test/order-b", seabird.PluginInfo{
+			OptionalDependencies: []string{"test/order-a"},
+		}),
+		orderPlugin("test/order-c", seabird.PluginInfo{}),
+		orderPlugin("test/cycle-a", seabird.PluginInfo{
+			Dependencies: []string{"test/cycle-b"},
+		}),
+		orderPlugin("test/cycle-b", seabird.PluginInfo{
+			Dependencies: []string{"test/cycle-a"},
+		}),
+	}
+}
+
+// runOrderTest loads the given plugins and returns the order they were loaded
+// in along with any error which stopped the bot from connecting.
+func runOrderTest(t *testing.T, plugins string) ([]string, error) {
+	var loadOrder []string
+
+	b := newTestBot(t, `plugins = [`+plugins+`]`, orderPlugins(&loadOrder)...)
+
+	// The test connection always ends with an EOF.
+	_, err := runTestBotErr(t, b)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	return loadOrder, err
+}
+
+func TestPluginLoadOrder(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		loadOrder, err := runOrderTest(t, `"test/order-*"`)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test/order-c", "test/order-a", "test/order-b"}, loadOrder)
+	}
+
+	// Optional dependencies don't need to be enabled.
+	loadOrder, err := runOrderTest(t, `"test/order-b"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test/order-b"}, loadOrder)
+}
+
+// registerOnce keeps TestRegisterPlugin from registering its plugin again if
+// the tests are run more than once.
+var registerOnce sync.Once
+
+func TestRegisterPlugin(t *testing.T) {
+	factory := func(b *seabird.Bot) error { return nil }
+
+	registerOnce.Do(func() {
+		seabird.RegisterPluginWithInfo("test/register", factory, seabird.PluginInfo{
+			Description: "Registered globally",
+		})
+	})
+
+	info, ok := seabird.GetPluginInfo("test/register")
+	assert.True(t, ok)
+	assert.Equal(t, "Registered globally", info.Description)
+
+	_, ok = seabird.GetPluginInfo("test/not-registered")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { seabird.RegisterPlugin("test/register", factory) })
+}
+
+func TestPluginMissingDependency(t *testing.T) {
+	loadOrder, err := runOrderTest(t, `"test/order-a", "test/order-b"`)
+	assert.EqualError(t, err, `Plugin "test/order-a" depends on "test/order-c" which is not enabled`)
+	assert.Empty(t, loadOrder)
+}
+
+func TestPluginDependencyCycle(t *testing.T) {
+	loadOrder, err := runOrderTest(t, `"test/cycle-*"`)
+	assert.EqualError(t, err, "Plugin dependency cycle: test/cycle-a -> test/cycle-b -> test/cycle-a")
+	assert.Empty(t, loadOrder)
+}
+
+func TestPluginBlacklist(t *testing.T) {
+	var tests = []struct {
+		config   string
+		expected []string
+	}{
+		{
+			`plugins = ["test/order-*", "!test/order-b"]`,
+			[]string{"test/order-c", "test/order-a"},
+		},
+		{
+			"plugins = [\"test/order-*\"]\ndisabled_plugins = [\"test/order-b\"]",
+			[]string{"test/order-c", "test/order-a"},
+		},
+		{
+			"plugins = [\"test/order-*\", \"!test/order-a\"]\ndisabled_plugins = [\"test/order-c\"]",
+			[]string{"test/order-b"},
+		},
+	}
+
+	var loadOrder []string
+
+	plugins := orderPlugins(&loadOrder)
+
+	for _, test := range tests {
+		report, err := checkTestConfig(test.config, plugins...)
+		require.NoError(t, err, test.config)
+		assert.Equal(t, test.expected, report.Plugins, test.config)
+	}
+
+	// Negated globs are evaluated after the whitelist, so a missing
+	// dependency is still an error.
+	_, err := checkTestConfig(`plugins = ["test/order-*", "!test/order-c"]`, plugins...)
+	assert.Error(t, err)
+}
+
+var errPluginBoom = errors.New("boom")
+
+// failPlugins returns a chain of plugins where the one at the bottom fails.
+func failPlugins(failBaseLoads *int) []testPlugin {
+	return []testPlugin{
+		plugin("test/fail-base", func(b *seabird.Bot) error {
+			*failBaseLoads++
+
+			// This should be removed when the plugin fails.
+			b.CommandMux().Event("failbase", func(r *seabird.Request) {}, nil)
+
+			return errPluginBoom
+		}),
+		plugin("test/fail-mid", func(b *seabird.Bot) error {
+			return b.EnsurePlugin("test/fail-base")
+		}),
+		{"test/fail-top", func(b *seabird.Bot) error {
+			return nil
+		}, seabird.PluginInfo{
+			Dependencies: []string{"test/fail-mid"},
+		}},
+	}
+}
+
+func TestPluginFailure(t *testing.T) {
+	var failBaseLoads int
+
+	b := newTestBot(t, `plugins = ["test/fail-*"]`, failPlugins(&failBaseLoads)...)
+
+	_, err := runTestBotErr(t, b)
+
+	var pluginErr *seabird.PluginError
+	require.True(t, errors.As(err, &pluginErr))
+	assert.Equal(t, []string{"test/fail-base"}, pluginErr.Path())
+	assert.True(t, errors.Is(err, errPluginBoom))
+}
+
+func TestPluginFailureNonFatal(t *testing.T) {
+	var (
+		failBaseLoads int
+		loadOrder     []string
+	)
+
+	b := newTestBot(t, `
+plugins = ["test/fail-*", "test/order-c"]
+nonfatalplugins = true
+`, append(failPlugins(&failBaseLoads), orderPlugins(&loadOrder)...)...)
+
+	sent := runTestBot(t, b,
+		"001 bot :Welcome",
+		":belak!~belak@host PRIVMSG #chan :!help failbase",
+	)
+
+	// The failed plugin should only have been tried once, even though two
+	// plugins depend on it.
+	assert.Equal(t, 1, failBaseLoads)
+	assert.Equal(t, []string{"test/order-c"}, b.LoadedPlugins())
+
+	failed := b.FailedPlugins()
+	assert.Len(t, failed, 3)
+
+	var pluginErr *seabird.PluginError
+	require.True(t, errors.As(failed["test/fail-top"], &pluginErr))
+	assert.Equal(t, []string{"test/fail-top", "test/fail-mid", "test/fail-base"}, pluginErr.Path())
+	assert.True(t, errors.Is(pluginErr, errPluginBoom))
+	assert.EqualError(t, pluginErr, "Plugin test/fail-top -> test/fail-mid -> test/fail-base failed to load: boom")
+
+	// Anything registered by the failed plugin should have been removed.
+	assertSent(t, sent, `PRIVMSG #chan :belak: There is no help available for command "failbase"`)
+}
@@ -0,0 +1,356 @@
+package seabird
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	irc "gopkg.in/irc.v3"
+)
+
+// Priority determines the order in which queued outgoing messages are sent.
+// All messages of a higher priority are sent before any of a lower priority.
+type Priority int
+
+const (
+	// PriorityControl is used for protocol and control messages like JOIN,
+	// MODE and anything else which isn't a PRIVMSG or NOTICE.
+	PriorityControl Priority = iota
+
+	// PriorityReply is the default priority for PRIVMSG and NOTICE.
+	PriorityReply
+
+	// PriorityBulk is for large amounts of low priority output. Bulk
+	// messages may be dropped if they have been queued for too long.
+	PriorityBulk
+
+	numPriorities
+)
+
+// QueueStats contains information about the outgoing message queue.
+type QueueStats struct {
+	// Pending is the number of messages waiting to be sent for each
+	// priority.
+	Pending [numPriorities]int
+
+	// Dropped is the number of stale bulk messages which were dropped.
+	Dropped int
+
+	// Coalesced is the number of bulk messages which were replaced by a newer
+	// message with the same key before being sent.
+	Coalesced int
+}
+
+type queuedMessage struct {
+	line   string
+	key    string
+	queued time.Time
+}
+
+// fairQueue is a set of FIFO queues, one per target, which are serviced in a
+// round-robin order.
+type fairQueue struct {
+	targets map[string][]*queuedMessage
+	order   []string
+	count   int
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{
+		targets: make(map[string][]*queuedMessage),
+	}
+}
+
+// push adds a message to the queue. If key is not empty and there is already a
+// message for the same target with the same key, it will be replaced. push
+// returns true if a message was replaced.
+func (q *fairQueue) push(target string, m *queuedMessage) bool {
+	pending, ok := q.targets[target]
+
+	if m.key != "" {
+		for i, existing := range pending {
+			if existing.key == m.key {
+				pending[i] = m
+				return true
+			}
+		}
+	}
+
+	if !ok {
+		q.order = append(q.order, target)
+	}
+
+	q.targets[target] = append(pending, m)
+	q.count++
+
+	return false
+}
+
+// pop returns the next message from the next target in the round-robin order
+// or nil if the queue is empty.
+func (q *fairQueue) pop() *queuedMessage {
+	if len(q.order) == 0 {
+		return nil
+	}
+
+	target := q.order[0]
+	q.order = q.order[1:]
+
+	pending := q.targets[target]
+	m := pending[0]
+	q.count--
+
+	if len(pending) > 1 {
+		q.targets[target] = pending[1:]
+		q.order = append(q.order, target)
+	} else {
+		delete(q.targets, target)
+	}
+
+	return m
+}
+
+// outgoingQueue holds all messages written by plugins until they can be sent
+// without going over the send limit.
+type outgoingQueue struct {
+	lock    sync.Mutex
+	classes [numPriorities]*fairQueue
+	notify  chan struct{}
+
+	// maxBulkAge is how long a bulk message can wait before it's dropped. If
+	// it's 0, bulk messages are never dropped.
+	maxBulkAge time.Duration
+
+	// limit and burst control how fast messages are sent. They can be changed
+	// while the queue is running.
+	limit        time.Duration
+	burst        int
+	limitChanged chan struct{}
+
+	dropped   int
+	coalesced int
+}
+
+func newOutgoingQueue() *outgoingQueue {
+	q := &outgoingQueue{
+		notify:       make(chan struct{}, 1),
+		limitChanged: make(chan struct{}, 1),
+	}
+
+	q.reset()
+
+	return q
+}
+
+// reset drops all pending messages.
+func (q *outgoingQueue) reset() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i := range q.classes {
+		q.classes[i] = newFairQueue()
+	}
+}
+
+func (q *outgoingQueue) push(p Priority, target, key, line string) {
+	if p < 0 || p >= numPriorities {
+		p = PriorityReply
+	}
+
+	q.lock.Lock()
+
+	if q.classes[p].push(target, &queuedMessage{line: line, key: key, queued: time.Now()}) {
+		q.coalesced++
+	}
+
+	q.lock.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next line to send or an empty string if there is nothing to
+// send.
+func (q *outgoingQueue) pop() string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for p, class := range q.classes {
+		for m := class.pop(); m != nil; m = class.pop() {
+			if Priority(p) == PriorityBulk && q.maxBulkAge > 0 && time.Since(m.queued) > q.maxBulkAge {
+				q.dropped++
+				continue
+			}
+
+			return m.line
+		}
+	}
+
+	return ""
+}
+
+func (q *outgoingQueue) stats() QueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	ret := QueueStats{
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+	}
+
+	for p, class := range q.classes {
+		ret.Pending[p] = class.count
+	}
+
+	return ret
+}
+
+// setLimit changes the send limit. If limit is not 0, a token bucket is used
+// so at most burst messages are sent at once and one more is allowed every
+// limit.
+func (q *outgoingQueue) setLimit(limit time.Duration, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	q.lock.Lock()
+	q.limit, q.burst = limit, burst
+	q.lock.Unlock()
+
+	select {
+	case q.limitChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (q *outgoingQueue) limits() (time.Duration, int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.limit, q.burst
+}
+
+// run sends queued messages to the client until the context is canceled.
+func (q *outgoingQueue) run(ctx context.Context, c *irc.Client) {
+	var (
+		ticker *time.Ticker
+		tick   <-chan time.Time
+	)
+
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	limit, burst := q.limits()
+	tokens := burst
+
+	resetTicker := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+
+		if limit > 0 {
+			ticker = time.NewTicker(limit)
+			tick = ticker.C
+		}
+	}
+
+	resetTicker()
+
+	for {
+		if limit == 0 || tokens > 0 {
+			if line := q.pop(); line != "" {
+				c.Write(line)
+
+				// Tokens are only used while there is a limit, otherwise
+				// setting one later would have to wait for them to be paid
+				// back.
+				if limit > 0 {
+					tokens--
+				}
+
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.notify:
+		case <-q.limitChanged:
+			limit, burst = q.limits()
+			if tokens > burst {
+				tokens = burst
+			} else if tokens < 0 {
+				tokens = 0
+			}
+
+			resetTicker()
+		case <-tick:
+			if tokens < burst {
+				tokens++
+			}
+		}
+	}
+}
+
+// flush sends everything remaining in the queue, ignoring the send limit. If
+// a write fails, the rest of the queue is dropped. It returns the number of
+// messages which couldn't be sent.
+func (q *outgoingQueue) flush(c *irc.Client) int {
+	for line := q.pop(); line != ""; line = q.pop() {
+		if err := c.Write(line); err != nil {
+			dropped := 1
+			for line = q.pop(); line != ""; line = q.pop() {
+				dropped++
+			}
+
+			return dropped
+		}
+	}
+
+	return 0
+}
+
+// defaultPriority returns the priority a message should be sent with if one
+// wasn't specified.
+func defaultPriority(m *irc.Message) Priority {
+	if m.Command == "PRIVMSG" || m.Command == "NOTICE" {
+		return PriorityReply
+	}
+
+	return PriorityControl
+}
+
+// messageTarget returns the name used for round-robin fairness between
+// messages of the same priority.
+func messageTarget(m *irc.Message) string {
+	if (m.Command == "PRIVMSG" || m.Command == "NOTICE") && len(m.Params) > 0 {
+		return m.Params[0]
+	}
+
+	return ""
+}
+
+// WritePriority queues a message to be sent with the given priority.
+func (b *Bot) WritePriority(m *irc.Message, p Priority) {
+	b.queue.push(p, messageTarget(m), "", m.String())
+}
+
+// WriteBulk queues a low priority message. If key is not empty, any message
+// still waiting to be sent to the same target with the same key will be
+// replaced by this one. Bulk messages which have been waiting longer than the
+// configured BulkMaxAge are dropped.
+func (b *Bot) WriteBulk(m *irc.Message, key string) {
+	b.queue.push(PriorityBulk, messageTarget(m), key, m.String())
+}
+
+// QueueStats returns information about the outgoing message queue.
+func (b *Bot) QueueStats() QueueStats {
+	return b.queue.stats()
+}
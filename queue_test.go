@@ -0,0 +1,134 @@
+package seabird
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	irc "gopkg.in/irc.v3"
+)
+
+func popAll(q *outgoingQueue) []string {
+	var ret []string
+
+	for line := q.pop(); line != ""; line = q.pop() {
+		ret = append(ret, line)
+	}
+
+	return ret
+}
+
+func TestOutgoingQueuePriorities(t *testing.T) {
+	q := newOutgoingQueue()
+
+	q.push(PriorityBulk, "#a", "", "bulk")
+	q.push(PriorityReply, "#a", "", "reply")
+	q.push(PriorityControl, "", "", "control")
+
+	assert.Equal(t, QueueStats{Pending: [numPriorities]int{1, 1, 1}}, q.stats())
+	assert.Equal(t, []string{"control", "reply", "bulk"}, popAll(q))
+}
+
+func TestOutgoingQueueFairness(t *testing.T) {
+	q := newOutgoingQueue()
+
+	for _, line := range []string{"a1", "a2", "a3"} {
+		q.push(PriorityReply, "#a", "", line)
+	}
+
+	q.push(PriorityReply, "#b", "", "b1")
+	q.push(PriorityReply, "#c", "", "c1")
+	q.push(PriorityReply, "#b", "", "b2")
+
+	assert.Equal(t, []string{"a1", "b1", "c1", "a2", "b2", "a3"}, popAll(q))
+}
+
+func TestOutgoingQueueBulk(t *testing.T) {
+	q := newOutgoingQueue()
+
+	// Messages with the same key should be coalesced
+	q.push(PriorityBulk, "#a", "status", "status 1")
+	q.push(PriorityBulk, "#a", "other", "other")
+	q.push(PriorityBulk, "#a", "status", "status 2")
+	q.push(PriorityBulk, "#b", "status", "status b")
+
+	assert.Equal(t, 1, q.stats().Coalesced)
+	assert.Equal(t, []string{"status 2", "status b", "other"}, popAll(q))
+
+	// Stale messages should be dropped
+	q.maxBulkAge = time.Millisecond
+	q.push(PriorityBulk, "#a", "", "stale")
+	time.Sleep(5 * time.Millisecond)
+	q.push(PriorityReply, "#a", "", "reply")
+
+	assert.Equal(t, []string{"reply"}, popAll(q))
+	assert.Equal(t, 1, q.stats().Dropped)
+}
+
+// lineWriter collects everything written to it so it can be used as the
+// connection for an irc.Client.
+type lineWriter struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (w *lineWriter) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.lines = append(w.lines, strings.TrimRight(string(p), "\r\n"))
+
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	return nil
+}
+
+func (w *lineWriter) count() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return len(w.lines)
+}
+
+func TestOutgoingQueueLimitChange(t *testing.T) {
+	w := &lineWriter{}
+	c := irc.NewClient(w, irc.ClientConfig{})
+
+	q := newOutgoingQueue()
+	q.setLimit(0, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go q.run(ctx, c)
+
+	// With no limit, everything should go out straight away.
+	for i := 0; i < 50; i++ {
+		q.push(PriorityReply, "#a", "", "PRIVMSG #a :unlimited")
+	}
+
+	require.Eventually(t, func() bool { return w.count() == 50 }, time.Second, time.Millisecond)
+
+	// Setting a limit after a lot of unlimited output shouldn't hold back
+	// the next message.
+	q.setLimit(time.Hour, 1)
+	q.push(PriorityReply, "#a", "", "PRIVMSG #a :limited")
+
+	require.Eventually(t, func() bool { return w.count() == 51 }, time.Second, time.Millisecond)
+
+	// The burst is used up, so anything else has to wait.
+	q.push(PriorityReply, "#a", "", "PRIVMSG #a :waiting")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 51, w.count())
+}
@@ -0,0 +1,323 @@
+package seabird
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/belak/go-seabird/internal"
+)
+
+// PermissionRateLimitExempt allows a user to ignore all command rate limits.
+const PermissionRateLimitExempt = "ratelimit.exempt"
+
+// RateLimitScope determines who shares a rate limit.
+type RateLimitScope int
+
+const (
+	// RateLimitUser gives every user their own limit.
+	RateLimitUser RateLimitScope = iota
+
+	// RateLimitChannel gives every channel its own limit. Private messages
+	// are limited per user.
+	RateLimitChannel
+
+	// RateLimitGlobal is a single limit shared by everyone.
+	RateLimitGlobal
+)
+
+func (s RateLimitScope) String() string {
+	switch s {
+	case RateLimitChannel:
+		return "channel"
+	case RateLimitGlobal:
+		return "global"
+	default:
+		return "user"
+	}
+}
+
+// parseRateLimitScope is the opposite of RateLimitScope.String.
+func parseRateLimitScope(scope string) (RateLimitScope, error) {
+	switch scope {
+	case "", "user":
+		return RateLimitUser, nil
+	case "channel":
+		return RateLimitChannel, nil
+	case "global":
+		return RateLimitGlobal, nil
+	default:
+		return RateLimitUser, fmt.Errorf("unknown scope %q", scope)
+	}
+}
+
+// RateLimit is a token bucket for a command. Each call uses a token and a new
+// token is added every Interval, up to Burst tokens. With a Burst of 0 or 1,
+// this is a simple cooldown.
+type RateLimit struct {
+	Scope    RateLimitScope
+	Interval time.Duration
+	Burst    int
+
+	// Notice makes the bot tell users when they've been limited with a
+	// private notice. It is only sent once until the user is allowed to call
+	// the command again. By default, limited calls are silently ignored.
+	Notice bool
+}
+
+// validate checks a RateLimit registered from code the same way
+// rateLimitConfig.validate checks the ones from the config.
+func (l RateLimit) validate() error {
+	if l.Scope < RateLimitUser || l.Scope > RateLimitGlobal {
+		return fmt.Errorf("unknown scope %d", l.Scope)
+	}
+
+	if l.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	if l.Burst < 0 {
+		return errors.New("burst must not be negative")
+	}
+
+	return nil
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+
+	return float64(l.Burst)
+}
+
+// rateLimitConfig is a RateLimit from the core config.
+type rateLimitConfig struct {
+	Scope    string
+	Interval internal.Duration
+	Burst    int
+	Notice   bool
+}
+
+// rateLimit converts the config into a RateLimit. It must have already been
+// validated.
+func (c rateLimitConfig) rateLimit() RateLimit {
+	scope, _ := parseRateLimitScope(c.Scope)
+
+	return RateLimit{
+		Scope:    scope,
+		Interval: c.Interval.Duration,
+		Burst:    c.Burst,
+		Notice:   c.Notice,
+	}
+}
+
+func (c rateLimitConfig) validate(command string) error {
+	key := "ratelimits." + command
+
+	if _, err := parseRateLimitScope(c.Scope); err != nil {
+		return newConfigError("core", key+".scope", "%s", err)
+	}
+
+	if c.Interval.Duration <= 0 {
+		return newConfigError("core", key+".interval", "must be positive")
+	}
+
+	if c.Burst < 0 {
+		return newConfigError("core", key+".burst", "must not be negative")
+	}
+
+	return nil
+}
+
+// tokenBucket is the state of a single RateLimit for one user, channel or the
+// whole bot.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+
+	// full is when the bucket will have refilled completely, so it can be
+	// removed.
+	full time.Time
+
+	// notified is set once a call has been rejected because of this bucket
+	// so the user is only told about it once. It is cleared when a token is
+	// taken.
+	notified bool
+}
+
+// rateLimiter tracks the token buckets for all commands. It is safe for
+// concurrent use.
+type rateLimiter struct {
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+
+	// now is used to get the current time so tests can control it.
+	now func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// rateLimitSweepInterval is how often buckets which have refilled are removed.
+const rateLimitSweepInterval = 10 * time.Minute
+
+// allow checks every limit for the command. If all of them have a token
+// available, one is taken from each and it returns true. Otherwise nothing is
+// taken and it returns how long until the call would be allowed, along with
+// whether this is the first rejection since a bucket which is out of tokens was
+// last used, so the user can be notified.
+func (l *rateLimiter) allow(r *Request, command string, limits []RateLimit) (bool, time.Duration, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	buckets := make([]*tokenBucket, len(limits))
+
+	var (
+		wait   time.Duration
+		notify bool
+	)
+
+	for i, limit := range limits {
+		key := fmt.Sprintf("%s\x00%d\x00%s", command, i, rateLimitKey(r, limit.Scope))
+
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: limit.burst(), last: now}
+			l.buckets[key] = b
+		}
+
+		// Refill the bucket based on how long it's been since it was last
+		// used.
+		b.tokens += float64(now.Sub(b.last)) / float64(limit.Interval)
+		if b.tokens > limit.burst() {
+			b.tokens = limit.burst()
+		}
+
+		b.last = now
+		b.full = now.Add(time.Duration((limit.burst() - b.tokens + 1) * float64(limit.Interval)))
+		buckets[i] = b
+
+		if b.tokens < 1 {
+			if w := time.Duration((1 - b.tokens) * float64(limit.Interval)); w > wait {
+				wait = w
+			}
+
+			if !b.notified {
+				b.notified = true
+				notify = true
+			}
+		}
+	}
+
+	if wait > 0 {
+		return false, wait, notify
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+		b.notified = false
+	}
+
+	return true, 0, false
+}
+
+// sweep removes any buckets which have been idle long enough that they are
+// full again. It must be called with the lock held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.After(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitKey returns the key identifying who a request should be limited as
+// for the given scope.
+func rateLimitKey(r *Request, scope RateLimitScope) string {
+	if scope == RateLimitGlobal {
+		return ""
+	}
+
+	if scope == RateLimitChannel && r.FromChannel() {
+		return "channel:" + r.ISupport().ToLower(r.Message.Params[0])
+	}
+
+	// Users are tracked by account if possible, falling back to their user
+	// and host so changing nick doesn't get around a limit.
+	if account := senderAccount(r); account != "" {
+		return "account:" + r.ISupport().ToLower(account)
+	}
+
+	if r.Message.Prefix == nil {
+		return "user:"
+	}
+
+	if r.Message.Prefix.Host != "" {
+		return "host:" + r.Message.Prefix.User + "@" + r.Message.Prefix.Host
+	}
+
+	return "user:" + r.ISupport().ToLower(r.Message.Prefix.Name)
+}
+
+// rateLimitMiddleware applies the rate limits for a command. Limits from the
+// config replace the ones the command was registered with.
+func (m *CommandMux) rateLimitMiddleware(command string, registered []RateLimit) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r *Request) {
+			limits := registered
+
+			m.lock.RLock()
+			if override, ok := m.rateLimits[command]; ok {
+				limits = []RateLimit{override}
+			}
+			m.lock.RUnlock()
+
+			if len(limits) == 0 || r.HasPermission(PermissionRateLimitExempt) {
+				next(r)
+				return
+			}
+
+			ok, wait, notify := m.limiter.allow(r, command, limits)
+			if ok {
+				next(r)
+				return
+			}
+
+			r.GetLogger("ratelimit").Debugf("Rate limited %s for %s", command, r.Message.Prefix)
+
+			// Users are only told once per cooldown so someone spamming a
+			// command can't make the bot flood them with notices.
+			if !notify || r.Message.Prefix == nil {
+				return
+			}
+
+			for _, limit := range limits {
+				if limit.Notice {
+					// Always round up so we never tell someone to wait 0s.
+					wait = (wait + time.Second - 1).Truncate(time.Second)
+
+					r.writeSplit("NOTICE", r.Message.Prefix.Name, "",
+						fmt.Sprintf("You're using %s%s too often. Try again in %s.", m.PrefixFor(r), command, wait))
+
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package seabird
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	irc "gopkg.in/irc.v3"
+)
+
+func rateLimitRequest(line string) *Request {
+	return NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage(line))
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	limits := []RateLimit{{Interval: 10 * time.Second, Burst: 2}}
+	r := rateLimitRequest(":a!~a@a.host PRIVMSG #chan :!cmd")
+
+	ok, _, _ := l.allow(r, "cmd", limits)
+	assert.True(t, ok)
+	ok, _, _ = l.allow(r, "cmd", limits)
+	assert.True(t, ok)
+
+	ok, wait, _ := l.allow(r, "cmd", limits)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	// Half a token isn't enough.
+	now = now.Add(5 * time.Second)
+	ok, wait, _ = l.allow(r, "cmd", limits)
+	assert.False(t, ok)
+	assert.Equal(t, 5*time.Second, wait)
+
+	now = now.Add(5 * time.Second)
+	ok, _, _ = l.allow(r, "cmd", limits)
+	assert.True(t, ok)
+
+	// Changing nick doesn't get around the limit, but other users and other
+	// commands have their own buckets.
+	ok, _, _ = l.allow(rateLimitRequest(":b!~a@a.host PRIVMSG #chan :!cmd"), "cmd", limits)
+	assert.False(t, ok)
+	ok, _, _ = l.allow(rateLimitRequest(":c!~c@c.host PRIVMSG #chan :!cmd"), "cmd", limits)
+	assert.True(t, ok)
+	ok, _, _ = l.allow(r, "other", limits)
+	assert.True(t, ok)
+
+	// Once everything has refilled, the buckets are cleaned up.
+	now = now.Add(time.Hour)
+	ok, _, _ = l.allow(r, "cmd", limits)
+	assert.True(t, ok)
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestRateLimiterNotify(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	limits := []RateLimit{{Interval: 10 * time.Second}}
+	r := rateLimitRequest(":a!~a@a.host PRIVMSG #chan :!cmd")
+
+	ok, _, notify := l.allow(r, "cmd", limits)
+	assert.True(t, ok)
+	assert.False(t, notify)
+
+	// Only the first rejection should notify.
+	_, _, notify = l.allow(r, "cmd", limits)
+	assert.True(t, notify)
+	_, _, notify = l.allow(r, "cmd", limits)
+	assert.False(t, notify)
+
+	// Once a call is allowed again, the next rejection notifies.
+	now = now.Add(10 * time.Second)
+	ok, _, _ = l.allow(r, "cmd", limits)
+	assert.True(t, ok)
+	_, _, notify = l.allow(r, "cmd", limits)
+	assert.True(t, notify)
+}
+
+func TestRateLimiterScopes(t *testing.T) {
+	l := newRateLimiter()
+
+	channel := []RateLimit{{Scope: RateLimitChannel, Interval: time.Hour}}
+	global := []RateLimit{{Scope: RateLimitGlobal, Interval: time.Hour}}
+
+	ok, _, _ := l.allow(rateLimitRequest(":a!~a@a.host PRIVMSG #chan :!cmd"), "chan", channel)
+	assert.True(t, ok)
+	ok, _, _ = l.allow(rateLimitRequest(":b!~b@b.host PRIVMSG #CHAN :!cmd"), "chan", channel)
+	assert.False(t, ok)
+	ok, _, _ = l.allow(rateLimitRequest(":b!~b@b.host PRIVMSG #other :!cmd"), "chan", channel)
+	assert.True(t, ok)
+
+	ok, _, _ = l.allow(rateLimitRequest(":a!~a@a.host PRIVMSG #chan :!cmd"), "global", global)
+	assert.True(t, ok)
+	ok, _, _ = l.allow(rateLimitRequest(":b!~b@b.host PRIVMSG bot :!cmd"), "global", global)
+	assert.False(t, ok)
+
+	// If any limit rejects the call, no tokens are taken from the others.
+	both := []RateLimit{{Interval: time.Hour}, {Scope: RateLimitGlobal, Interval: time.Hour, Burst: 2}}
+	ok, _, _ = l.allow(rateLimitRequest(":a!~a@a.host PRIVMSG #chan :!cmd"), "both", both)
+	assert.True(t, ok)
+	ok, _, _ = l.allow(rateLimitRequest(":a!~a@a.host PRIVMSG #chan :!cmd"), "both", both)
+	assert.False(t, ok)
+	ok, _, _ = l.allow(rateLimitRequest(":b!~b@b.host PRIVMSG #chan :!cmd"), "both", both)
+	assert.True(t, ok)
+	ok, _, _ = l.allow(rateLimitRequest(":c!~c@c.host PRIVMSG #chan :!cmd"), "both", both)
+	assert.False(t, ok)
+}
+
+func TestRateLimitValidate(t *testing.T) {
+	var tests = []struct {
+		limit RateLimit
+		err   string
+	}{
+		{RateLimit{Interval: time.Second}, ""},
+		{RateLimit{Scope: RateLimitGlobal, Interval: time.Second, Burst: 3}, ""},
+		{RateLimit{}, "interval must be positive"},
+		{RateLimit{Interval: -time.Second}, "interval must be positive"},
+		{RateLimit{Interval: time.Second, Burst: -1}, "burst must not be negative"},
+		{RateLimit{Scope: RateLimitScope(10), Interval: time.Second}, "unknown scope 10"},
+	}
+
+	for _, tt := range tests {
+		err := tt.limit.validate()
+		if tt.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+
+	// Invalid limits from code are rejected when the command is registered.
+	m := NewCommandMux("!")
+	assert.PanicsWithValue(t, `Command "cmd": rate limit 0: interval must be positive`, func() {
+		m.Event("cmd", func(r *Request) {}, &HelpInfo{RateLimits: []RateLimit{{Burst: 1}}})
+	})
+	assert.Panics(t, func() {
+		m.SetRateLimits(map[string]RateLimit{"cmd": {Interval: time.Second, Burst: -1}})
+	})
+}
@@ -0,0 +1,283 @@
+package seabird
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveConn handles a single connection to a fake server. It waits for the
+// client to register, sends the given lines, then keeps reading until it sees
+// the until line before closing the connection. All the lines the client sent
+// are returned.
+func serveConn(conn net.Conn, send []string, until string) []string {
+	defer conn.Close()
+
+	var ret []string
+
+	scanner := bufio.NewScanner(conn)
+	readUntil := func(prefix string) {
+		for scanner.Scan() {
+			ret = append(ret, scanner.Text())
+			if prefix != "" && strings.HasPrefix(scanner.Text(), prefix) {
+				return
+			}
+		}
+	}
+
+	readUntil("USER ")
+
+	for _, line := range send {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	if until != "" {
+		readUntil(until)
+	}
+
+	// Closing our side first and reading everything the client sends makes
+	// sure it sees all the lines we wrote.
+	_ = conn.(*net.TCPConn).CloseWrite()
+
+	readUntil("")
+
+	return ret
+}
+
+// startTestServer listens on a random port and uses the given handlers for
+// each connection in order. Any connections after that are closed
+// immediately.
+func startTestServer(t *testing.T, handlers ...func(net.Conn)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			if i >= len(handlers) {
+				conn.Close()
+				continue
+			}
+
+			handlers[i](conn)
+		}
+	}()
+
+	return l
+}
+
+func newReconnectBot(t *testing.T, host string, extra string) *Bot {
+	b, err := newBot(strings.NewReader(`
+[core]
+nick = "bot"
+user = "bot"
+name = "bot"
+host = "`+host+`"
+`+extra), newPluginRegistry())
+	require.NoError(t, err)
+
+	return b
+}
+
+func TestReconnectDelay(t *testing.T) {
+	b := newReconnectBot(t, "localhost:6667", `
+reconnectdelay = "1s"
+reconnectmaxdelay = "10s"
+reconnectjitter = 0.0
+`)
+
+	var delays []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		delays = append(delays, b.reconnectDelay(attempt))
+	}
+
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	}, delays)
+
+	// Large attempt counts shouldn't overflow.
+	assert.Equal(t, 10*time.Second, b.reconnectDelay(1000))
+
+	// Jitter stays within the configured fraction.
+	b.config.ReconnectJitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := b.reconnectDelay(2)
+		assert.True(t, delay >= time.Second && delay <= 3*time.Second, "delay %s out of range", delay)
+	}
+}
+
+func TestReconnectBackoffAndRejoin(t *testing.T) {
+	var rejoinLines []string
+
+	done := make(chan struct{})
+	fail := func(conn net.Conn) { serveConn(conn, nil, "") }
+
+	l := startTestServer(t,
+		func(conn net.Conn) {
+			serveConn(conn, []string{
+				"001 bot :Welcome",
+				":bot!bot@host JOIN #a",
+				":bot!bot@host JOIN #b",
+			}, "")
+		},
+		fail,
+		fail,
+		fail,
+		func(conn net.Conn) {
+			rejoinLines = serveConn(conn, []string{"001 bot :Welcome"}, "JOIN #b")
+			close(done)
+		},
+	)
+	defer l.Close()
+
+	b := newReconnectBot(t, l.Addr().String(), `
+cmds = ["JOIN #a"]
+reconnectdelay = "1s"
+reconnectmaxdelay = "4s"
+reconnectjitter = 0.0
+`)
+
+	var delays []time.Duration
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b.wait = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		if len(delays) == 5 {
+			cancel()
+		}
+
+		return ctx.Err()
+	}
+
+	err := b.ConnectAndRunContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	// The backoff should grow until it hits the max, then start over once a
+	// connection gets through registration.
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Second,
+	}, delays)
+
+	// #a is joined by the cmds, so only #b should be rejoined, even though
+	// there were failed connections in between.
+	<-done
+	joins := make(map[string]int)
+	for _, line := range rejoinLines {
+		joins[line]++
+	}
+
+	assert.Equal(t, 1, joins["JOIN #a"])
+	assert.Equal(t, 1, joins["JOIN #b"])
+}
+
+func TestReconnectStops(t *testing.T) {
+	var tests = []struct {
+		name     string
+		config   string
+		setup    func(b *Bot)
+		send     []string
+		waits    int
+		errCheck func(t *testing.T, err error)
+	}{
+		{
+			name:   "SASLFailed",
+			config: `sasluser = "bot"` + "\n" + `saslpass = "pass"`,
+			send:   []string{"001 bot :Welcome"},
+			errCheck: func(t *testing.T, err error) {
+				assert.True(t, errors.Is(err, ErrSASLFailed), "unexpected error %v", err)
+			},
+		},
+		{
+			name:  "CapRequired",
+			setup: func(b *Bot) { b.CapRequest("account-tag", true) },
+			send:  []string{"CAP * LS :account-tag", "CAP * NAK :account-tag"},
+			errCheck: func(t *testing.T, err error) {
+				assert.True(t, errors.Is(err, ErrCapRequired), "unexpected error %v", err)
+			},
+		},
+		{
+			name:   "NoReconnect",
+			config: `noreconnect = true`,
+			errCheck: func(t *testing.T, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			name:   "MaxAttempts",
+			config: `reconnectmaxattempts = 2`,
+			waits:  2,
+			errCheck: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "giving up after 2 reconnect attempts: EOF")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(conn net.Conn) { serveConn(conn, tt.send, "") }
+
+			l := startTestServer(t, handler, handler, handler)
+			defer l.Close()
+
+			b := newReconnectBot(t, l.Addr().String(), tt.config)
+			if tt.setup != nil {
+				tt.setup(b)
+			}
+
+			waits := 0
+			b.wait = func(ctx context.Context, d time.Duration) error {
+				waits++
+				return nil
+			}
+
+			tt.errCheck(t, b.ConnectAndRunContext(context.Background()))
+			assert.Equal(t, tt.waits, waits)
+		})
+	}
+}
+
+func TestReconnectCancel(t *testing.T) {
+	connected := make(chan struct{})
+
+	l := startTestServer(t,
+		func(conn net.Conn) {
+			close(connected)
+			serveConn(conn, nil, "QUIT")
+		},
+	)
+	defer l.Close()
+
+	b := newReconnectBot(t, l.Addr().String(), `reconnectdelay = "1h"`)
+
+	// Canceling should close the current connection.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-connected
+		cancel()
+	}()
+
+	assert.Equal(t, context.Canceled, b.ConnectAndRunContext(ctx))
+
+	// Canceling should also interrupt waiting to reconnect.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, b.ConnectAndRunContext(ctx))
+	assert.True(t, time.Since(start) < time.Minute)
+}
@@ -0,0 +1,105 @@
+package seabird
+
+import (
+	"sync"
+)
+
+// Registration is a handle to a handler or middleware registered with one of
+// the muxes, or to a cap request. It can be used to remove it again, which is
+// useful for temporary listeners like waiting for a WHOIS reply.
+type Registration struct {
+	once   sync.Once
+	remove func()
+}
+
+func newRegistration(remove func()) *Registration {
+	return &Registration{remove: remove}
+}
+
+// Remove unregisters the handler. It is safe to call Remove multiple times and
+// from within a handler.
+func (reg *Registration) Remove() {
+	if reg == nil {
+		return
+	}
+
+	reg.once.Do(reg.remove)
+}
+
+// RegistrationGroup collects Registrations so they can all be removed
+// together, such as all the handlers belonging to a single plugin.
+type RegistrationGroup struct {
+	lock sync.Mutex
+	regs []*Registration
+}
+
+// NewRegistrationGroup returns an empty RegistrationGroup.
+func NewRegistrationGroup() *RegistrationGroup {
+	return &RegistrationGroup{}
+}
+
+// Add adds Registrations to the group.
+func (g *RegistrationGroup) Add(regs ...*Registration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.regs = append(g.regs, regs...)
+}
+
+// Remove removes all Registrations in the group. The group can be reused
+// afterwards.
+func (g *RegistrationGroup) Remove() {
+	g.lock.Lock()
+	regs := g.regs
+	g.regs = nil
+	g.lock.Unlock()
+
+	for _, reg := range regs {
+		reg.Remove()
+	}
+}
+
+// removeEntry returns a copy of the slice without the given entry. A copy is
+// made so any handlers currently being dispatched from the old slice are not
+// affected.
+func removeEntry(entries []*muxEntry, e *muxEntry) []*muxEntry {
+	ret := make([]*muxEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry != e {
+			ret = append(ret, entry)
+		}
+	}
+
+	return ret
+}
+
+// middlewareGroup is the middleware added by a single call to Use. It's kept
+// as a pointer so the same group can be found and removed again.
+type middlewareGroup struct {
+	middleware []Middleware
+}
+
+// removeMiddleware returns a copy of the slice without the given group.
+func removeMiddleware(groups []*middlewareGroup, g *middlewareGroup) []*middlewareGroup {
+	ret := make([]*middlewareGroup, 0, len(groups))
+
+	for _, group := range groups {
+		if group != g {
+			ret = append(ret, group)
+		}
+	}
+
+	return ret
+}
+
+// flattenMiddleware returns the middleware from all groups in order.
+func flattenMiddleware(groups []*middlewareGroup) []Middleware {
+	var ret []Middleware
+
+	for _, group := range groups {
+		ret = append(ret, group.middleware...)
+	}
+
+	return ret
+}
@@ -0,0 +1,77 @@
+package seabird_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	irc "gopkg.in/irc.v3"
+
+	"github.com/belak/go-seabird"
+)
+
+func TestBasicMuxRemove(t *testing.T) {
+	r := seabird.NewRequest(context.TODO(), nil, "bot", irc.MustParseMessage("001"))
+
+	mh := &messageHandler{}
+	mh2 := &messageHandler{}
+	mux := seabird.NewBasicMux()
+	reg := mux.Event("001", mh.Handle)
+	mux.Event("001", mh2.Handle)
+
+	mux.HandleEvent(r)
+	reg.Remove()
+	mux.HandleEvent(r)
+
+	// Removing twice should be safe
+	reg.Remove()
+
+	assert.Equal(t, 1, mh.count)
+	assert.Equal(t, 2, mh2.count)
+
+	// Handlers should be able to remove themselves
+	var count int
+
+	mux = seabird.NewBasicMux()
+	reg = mux.Event("001", func(r *seabird.Request) {
+		count++
+		reg.Remove()
+	})
+
+	mux.HandleEvent(r)
+	mux.HandleEvent(r)
+
+	assert.Equal(t, 1, count)
+}
+
+func TestRegistrationGroup(t *testing.T) {
+	ctx := context.TODO()
+
+	mh := &messageHandler{}
+	basic := seabird.NewBasicMux()
+	commands := seabird.NewCommandMux("!")
+	mentions := seabird.NewMentionMux()
+
+	group := seabird.NewRegistrationGroup()
+	group.Add(
+		basic.Event("PRIVMSG", mh.Handle),
+		commands.Event("hello", mh.Handle, nil),
+		mentions.Event(mh.Handle),
+	)
+
+	send := func() {
+		r := seabird.NewRequest(ctx, nil, "bot", irc.MustParseMessage(":belak PRIVMSG #hello :!hello"))
+		basic.HandleEvent(r)
+		commands.HandleEvent(r)
+
+		r = seabird.NewRequest(ctx, nil, "bot", irc.MustParseMessage(":belak PRIVMSG #hello :bot: hello"))
+		mentions.HandleEvent(r)
+	}
+
+	send()
+	assert.Equal(t, 3, mh.count)
+
+	group.Remove()
+	send()
+	assert.Equal(t, 3, mh.count)
+}
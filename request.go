@@ -13,6 +13,10 @@ type Request struct {
 
 	bot     *Bot
 	context context.Context
+
+	// handler is the registered HandlerFunc currently being called, so Async
+	// can attribute panics to it.
+	handler HandlerFunc
 }
 
 func NewRequest(ctx context.Context, b *Bot, currentNick string, m *irc.Message) *Request {
@@ -20,9 +24,9 @@ func NewRequest(ctx context.Context, b *Bot, currentNick string, m *irc.Message)
 	ctx = context.WithValue(ctx, contextKeyRequestID, uuid.New())
 
 	r := &Request{
-		m,
-		b,
-		ctx,
+		Message: m,
+		bot:     b,
+		context: ctx,
 	}
 
 	return r
@@ -30,9 +34,10 @@ func NewRequest(ctx context.Context, b *Bot, currentNick string, m *irc.Message)
 
 func (r *Request) Copy() *Request {
 	return &Request{
-		r.Message.Copy(),
-		r.bot,
-		r.context,
+		Message: r.Message.Copy(),
+		bot:     r.bot,
+		context: r.context,
+		handler: r.handler,
 	}
 }
 
@@ -52,13 +57,58 @@ func (r *Request) CurrentNick() string {
 	return CtxCurrentNick(r.context)
 }
 
+// CapEnabled returns true if the given IRCv3 cap was enabled when this request
+// was received. Plugins can use this to degrade gracefully when a cap they
+// requested is not available.
+func (r *Request) CapEnabled(name string) bool {
+	return CtxCapEnabled(r.context, name)
+}
+
+// Tracker returns the bot's channel and user state tracker. It will return nil
+// if this request was not created by a Bot.
+func (r *Request) Tracker() *Tracker {
+	if r.bot == nil {
+		return nil
+	}
+
+	return r.bot.Tracker()
+}
+
+// SenderIsOp returns true if this message came from a channel and the sender
+// is an op (or higher) in that channel.
+func (r *Request) SenderIsOp() bool {
+	t := r.Tracker()
+	if t == nil || !r.FromChannel() {
+		return false
+	}
+
+	return t.IsOp(r.Message.Params[0], r.Message.Prefix.Name)
+}
+
+// SenderIsVoiced returns true if this message came from a channel and the
+// sender is voiced (or higher) in that channel.
+func (r *Request) SenderIsVoiced() bool {
+	t := r.Tracker()
+	if t == nil || !r.FromChannel() {
+		return false
+	}
+
+	return t.IsVoiced(r.Message.Params[0], r.Message.Prefix.Name)
+}
+
 // FromChannel checks if this message came from a channel or not.
 func (r *Request) FromChannel() bool {
 	if len(r.Message.Params) < 1 {
 		return false
 	}
 
-	// The first param is the target, so if this doesn't match the current nick,
-	// the message came from a channel.
-	return r.Message.Params[0] != r.CurrentNick()
+	// The first param is the target, so if it's a channel name, the message
+	// came from a channel.
+	return r.ISupport().IsChannel(r.Message.Params[0])
+}
+
+// ISupport returns the RPL_ISUPPORT values which were in effect when this
+// request was received.
+func (r *Request) ISupport() *ISupport {
+	return CtxISupport(r.context)
 }
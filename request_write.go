@@ -6,8 +6,14 @@ import (
 	"strings"
 
 	irc "gopkg.in/irc.v3"
+
+	"github.com/belak/go-seabird/internal"
 )
 
+// truncationMarker is added to the end of a reply when lines were dropped
+// because of MaxReplyLines.
+const truncationMarker = " ...more"
+
 // Reply to a Request with a convenience wrapper around fmt.Sprintf.
 func (r *Request) Replyf(format string, v ...interface{}) error {
 	if len(r.Message.Params) < 1 || len(r.Message.Params[0]) < 1 {
@@ -19,17 +25,7 @@ func (r *Request) Replyf(format string, v ...interface{}) error {
 		target = r.Message.Params[0]
 	}
 
-	fullMsg := fmt.Sprintf(format, v...)
-	for _, resp := range strings.Split(fullMsg, "\n") {
-		r.WriteMessage(&irc.Message{
-			Prefix:  &irc.Prefix{},
-			Command: "PRIVMSG",
-			Params: []string{
-				target,
-				resp,
-			},
-		})
-	}
+	r.writeSplit("PRIVMSG", target, "", fmt.Sprintf(format, v...))
 
 	return nil
 }
@@ -49,31 +45,14 @@ func (r *Request) MentionReplyf(format string, v ...interface{}) error {
 		prefix = r.Message.Prefix.Name + ": "
 	}
 
-	fullMsg := fmt.Sprintf(format, v...)
-	for _, resp := range strings.Split(fullMsg, "\n") {
-		r.WriteMessage(&irc.Message{
-			Prefix:  &irc.Prefix{},
-			Command: "PRIVMSG",
-			Params: []string{
-				target,
-				prefix + resp,
-			},
-		})
-	}
+	r.writeSplit("PRIVMSG", target, prefix, fmt.Sprintf(format, v...))
 
 	return nil
 }
 
 // PrivateReply is similar to Reply, but it will always send privately.
 func (r *Request) PrivateReplyf(format string, v ...interface{}) {
-	r.WriteMessage(&irc.Message{
-		Prefix:  &irc.Prefix{},
-		Command: "PRIVMSG",
-		Params: []string{
-			r.Message.Prefix.Name,
-			fmt.Sprintf(format, v...),
-		},
-	})
+	r.writeSplit("PRIVMSG", r.Message.Prefix.Name, "", fmt.Sprintf(format, v...))
 }
 
 // CTCPReply is a convenience function to respond to CTCP requests.
@@ -94,6 +73,36 @@ func (r *Request) CTCPReplyf(format string, v ...interface{}) error {
 	return nil
 }
 
+// writeSplit sends a message to the given target, splitting it on newlines and
+// anywhere else needed to keep each line under the server's line length
+// limit. The prefix is added to the start of every line. If the bot has a
+// MaxReplyLines set, any extra lines will be dropped and the last line will be
+// marked as truncated.
+func (r *Request) writeSplit(command, target, prefix, msg string) {
+	maxLen := r.bot.maxMessageLen(r.CurrentNick(), command, target) - len(prefix)
+
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		lines = append(lines, internal.SplitMessage(line, maxLen)...)
+	}
+
+	if maxLines := r.bot.currentConfig().MaxReplyLines; maxLines > 0 && len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] = internal.TruncateString(lines[maxLines-1], maxLen-len(truncationMarker)) + truncationMarker
+	}
+
+	for _, line := range lines {
+		r.WriteMessage(&irc.Message{
+			Prefix:  &irc.Prefix{},
+			Command: command,
+			Params: []string{
+				target,
+				prefix + line,
+			},
+		})
+	}
+}
+
 // Send is a simple function to send an IRC event.
 func (r *Request) WriteMessage(m *irc.Message) {
 	r.bot.WriteMessage(m)
@@ -0,0 +1,97 @@
+package seabird_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	irc "gopkg.in/irc.v3"
+
+	"github.com/belak/go-seabird"
+)
+
+// splitPlugin registers commands with replies which need to be split.
+func splitPlugin() testPlugin {
+	return plugin("test/split", func(b *seabird.Bot) error {
+		b.CommandMux().Event("long", func(r *seabird.Request) {
+			r.Replyf("%s", strings.TrimSpace(strings.Repeat("word ", 300)))
+		}, nil)
+
+		b.CommandMux().Event("lines", func(r *seabird.Request) {
+			r.MentionReplyf("one\ntwo\nthree\nfour")
+		}, nil)
+
+		b.CommandMux().Event("blank", func(r *seabird.Request) {
+			r.Replyf("\n  one\n\n   \n" + strings.Repeat(" ", 600) + "two")
+			r.Replyf("")
+		}, nil)
+
+		return nil
+	})
+}
+
+func runSplitTest(t *testing.T, config string, lines ...string) []*irc.Message {
+	b := newTestBot(t, config, splitPlugin())
+
+	sent := runTestBot(t, b, append([]string{
+		"001 bot :Welcome to the network bot!~bot@some.host",
+	}, lines...)...)
+
+	return filterSent(sent, "PRIVMSG")
+}
+
+func TestReplySplitting(t *testing.T) {
+	sent := runSplitTest(t, "", ":belak!~belak@host PRIVMSG #chan :!long")
+	require.True(t, len(sent) > 1)
+
+	overhead := len(":bot!~bot@some.host \r\n")
+
+	var words int
+
+	for _, m := range sent {
+		line := m.String()
+		assert.True(t, len(line)+overhead <= 512, "line too long: %d", len(line)+overhead)
+		assert.Equal(t, []string{"#chan"}, m.Params[:1])
+		assert.True(t, strings.HasPrefix(m.Trailing(), "word"))
+		assert.False(t, strings.HasSuffix(m.Trailing(), " "))
+
+		words += len(strings.Fields(m.Trailing()))
+	}
+
+	assert.Equal(t, 300, words)
+}
+
+func TestReplyMaxLines(t *testing.T) {
+	sent := runSplitTest(t, "maxreplylines = 2", ":belak!~belak@host PRIVMSG #chan :!lines")
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :belak: one",
+		"PRIVMSG #chan :belak: two ...more",
+	)
+}
+
+func TestReplySkipsEmptyLines(t *testing.T) {
+	sent := runSplitTest(t, "", ":belak!~belak@host PRIVMSG #chan :!blank")
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :  one",
+		"PRIVMSG #chan two",
+	)
+}
+
+func TestQueueFlushedOnClose(t *testing.T) {
+	// With a send limit this slow, only the burst can go out before the
+	// connection closes. The rest has to be flushed before it's torn down.
+	sent := runSplitTest(t, `
+sendlimit = "1h"
+sendburst = 1
+`, ":belak!~belak@host PRIVMSG #chan :!lines")
+
+	assertSentExactly(t, sent, "PRIVMSG",
+		"PRIVMSG #chan :belak: one",
+		"PRIVMSG #chan :belak: two",
+		"PRIVMSG #chan :belak: three",
+		"PRIVMSG #chan :belak: four",
+	)
+}
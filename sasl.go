@@ -0,0 +1,83 @@
+package seabird
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	irc "gopkg.in/irc.v3"
+)
+
+// ErrSASLFailed is returned from Run and ConnectAndRun when SASL
+// authentication was configured but did not succeed.
+var ErrSASLFailed = errors.New("SASL authentication failed")
+
+// saslChunkSize is the maximum length of a single AUTHENTICATE payload.
+const saslChunkSize = 400
+
+// saslMechanism returns the SASL mechanism which should be used or an empty
+// string if SASL is not configured.
+func (c *coreConfig) saslMechanism() string {
+	if c.SASLMechanism != "" {
+		return strings.ToUpper(c.SASLMechanism)
+	}
+
+	if c.SASLUser != "" {
+		return "PLAIN"
+	}
+
+	return ""
+}
+
+// saslMechanism returns the SASL mechanism from the current config.
+func (b *Bot) saslMechanism() string {
+	config := b.currentConfig()
+	return config.saslMechanism()
+}
+
+func (b *Bot) startSASL(c *irc.Client) {
+	b.caps.saslStarted = true
+	c.Writef("AUTHENTICATE %s", b.saslMechanism())
+}
+
+func (b *Bot) handleAuthenticate(c *irc.Client, m *irc.Message) {
+	if !b.caps.saslStarted || len(m.Params) < 1 || m.Params[0] != "+" {
+		return
+	}
+
+	var payload string
+
+	config := b.currentConfig()
+
+	switch config.saslMechanism() {
+	case "PLAIN":
+		payload = config.SASLUser + "\x00" + config.SASLUser + "\x00" + config.SASLPass
+	case "EXTERNAL":
+		// The identity comes from the client certificate, so we send an empty
+		// response.
+	}
+
+	for _, line := range saslPayloadLines(payload) {
+		c.Writef("AUTHENTICATE %s", line)
+	}
+}
+
+// saslPayloadLines base64 encodes the payload and splits it into lines which
+// can be sent with AUTHENTICATE. An empty payload or one which is an exact
+// multiple of the chunk size is terminated with a "+".
+func saslPayloadLines(payload string) []string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+
+	var ret []string
+
+	for len(encoded) >= saslChunkSize {
+		ret = append(ret, encoded[:saslChunkSize])
+		encoded = encoded[saslChunkSize:]
+	}
+
+	if encoded == "" {
+		encoded = "+"
+	}
+
+	return append(ret, encoded)
+}
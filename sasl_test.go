@@ -0,0 +1,110 @@
+package seabird_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/belak/go-seabird"
+)
+
+const saslTestConfig = `
+saslmechanism = "plain"
+sasluser = "bot"
+saslpass = "hunter2"
+`
+
+func TestSASLPlain(t *testing.T) {
+	b := newTestBot(t, saslTestConfig)
+
+	sent := runTestBot(t, b,
+		"CAP * LS * :multi-prefix",
+		"CAP * LS :sasl=PLAIN,EXTERNAL",
+		"CAP * ACK :sasl",
+		"AUTHENTICATE +",
+		"903 bot :SASL authentication successful",
+		"001 bot :Welcome",
+	)
+
+	assert.Equal(t, normalizeLines(
+		"CAP LS 302",
+		"NICK :bot",
+		"USER bot 0 * :bot",
+		"CAP REQ :multi-prefix sasl",
+		"AUTHENTICATE PLAIN",
+		"AUTHENTICATE Ym90AGJvdABodW50ZXIy",
+		"CAP END",
+	), sentLines(sent))
+}
+
+func TestSASLFailure(t *testing.T) {
+	b := newTestBot(t, saslTestConfig)
+
+	_, err := runTestBotErr(t, b,
+		"CAP * LS :sasl",
+		"CAP * ACK :sasl",
+		"AUTHENTICATE +",
+		"904 bot :SASL authentication failed",
+	)
+	assert.True(t, errors.Is(err, seabird.ErrSASLFailed), "unexpected error: %v", err)
+}
+
+func TestSASLUnsupported(t *testing.T) {
+	b := newTestBot(t, saslTestConfig)
+
+	_, err := runTestBotErr(t, b,
+		"421 bot CAP :Unknown command",
+		"001 bot :Welcome",
+	)
+	assert.True(t, errors.Is(err, seabird.ErrSASLFailed), "unexpected error: %v", err)
+}
+
+func TestSASLExternal(t *testing.T) {
+	b := newTestBot(t, `
+saslmechanism = "external"
+tlscert = "cert.pem"
+tlskey = "key.pem"
+`)
+
+	sent := runTestBot(t, b,
+		"CAP * LS :sasl=PLAIN,EXTERNAL",
+		"CAP * ACK :sasl",
+		"AUTHENTICATE +",
+		"903 bot :SASL authentication successful",
+		"001 bot :Welcome",
+	)
+
+	// The identity comes from the client certificate, so the response is
+	// empty.
+	assert.Equal(t, normalizeLines(
+		"CAP LS 302",
+		"NICK :bot",
+		"USER bot 0 * :bot",
+		"CAP REQ :sasl",
+		"AUTHENTICATE EXTERNAL",
+		"AUTHENTICATE +",
+		"CAP END",
+	), sentLines(sent))
+}
+
+func TestSASLCapRejected(t *testing.T) {
+	b := newTestBot(t, saslTestConfig)
+
+	_, err := runTestBotErr(t, b,
+		"CAP * LS :sasl",
+		"CAP * NAK :sasl",
+		"001 bot :Welcome",
+	)
+	assert.True(t, errors.Is(err, seabird.ErrSASLFailed), "unexpected error: %v", err)
+}
+
+func TestSASLCapNotOffered(t *testing.T) {
+	b := newTestBot(t, saslTestConfig)
+
+	_, err := runTestBotErr(t, b,
+		"CAP * LS :multi-prefix",
+		"001 bot :Welcome",
+	)
+	assert.True(t, errors.Is(err, seabird.ErrSASLFailed), "unexpected error: %v", err)
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"bytes"
+	"io"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,6 +16,9 @@ import (
 type TestClientServer struct {
 	client *bytes.Buffer
 	server *bytes.Buffer
+
+	lock   sync.Mutex
+	closed bool
 }
 
 // NewTestClientServer returns a new TestClientServer.
@@ -29,8 +34,16 @@ func (cs *TestClientServer) Read(p []byte) (int, error) {
 	return cs.server.Read(p)
 }
 
-// Write is what will be going to the "server".
+// Write is what will be going to the "server". Writes fail once the
+// TestClientServer has been closed, like they would on a real connection.
 func (cs *TestClientServer) Write(p []byte) (int, error) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if cs.closed {
+		return 0, io.ErrClosedPipe
+	}
+
 	return cs.client.Write(p)
 }
 
@@ -52,7 +65,7 @@ func (cs *TestClientServer) CheckLines(t *testing.T, expected []string) bool {
 	ok := true
 
 	// Split all the lines
-	lines := strings.Split(cs.client.String(), "\r\n")
+	lines := strings.Split(cs.ClientString(), "\r\n")
 	// lines := strings.Split(strings.TrimRight(cs.client.String(), "\r\n"), "\r\n")
 
 	// Loop through all the expected lines
@@ -72,8 +85,32 @@ func (cs *TestClientServer) CheckLines(t *testing.T, expected []string) bool {
 	return ok
 }
 
-// Reset clears the contents of the internal buffers.
+// Reset clears the contents of the internal buffers and reopens the
+// TestClientServer.
 func (cs *TestClientServer) Reset() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.closed = false
 	cs.client.Reset()
 	cs.server.Reset()
 }
+
+// Close implements io.Closer so a TestClientServer can be passed to
+// seabird.Bot.Run. Anything written after it is closed is rejected.
+func (cs *TestClientServer) Close() error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.closed = true
+
+	return nil
+}
+
+// ClientString returns everything the client has sent so far.
+func (cs *TestClientServer) ClientString() string {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	return cs.client.String()
+}
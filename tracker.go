@@ -0,0 +1,679 @@
+package seabird
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	irc "gopkg.in/irc.v3"
+)
+
+// ChannelInfo is a snapshot of what the Tracker knows about a channel.
+type ChannelInfo struct {
+	Name  string
+	Topic string
+
+	// Users maps the nick of each user in the channel to their prefixes, such
+	// as "@" for ops or "+" for voice.
+	Users map[string]string
+}
+
+// UserInfo is a snapshot of what the Tracker knows about a user. User, Host
+// and Account may be empty if they are not known.
+type UserInfo struct {
+	Nick    string
+	User    string
+	Host    string
+	Account string
+
+	// Channels contains all the channels this user shares with the bot.
+	Channels []string
+}
+
+type trackedChannel struct {
+	name  string
+	topic string
+
+	// users maps a folded nick to that user's prefixes in this channel.
+	users map[string]string
+}
+
+type trackedUser struct {
+	nick    string
+	user    string
+	host    string
+	account string
+
+	// channels is the set of folded channel names this user is in.
+	channels map[string]bool
+}
+
+// Tracker keeps track of the channels the bot is in, the users in those
+// channels and their prefixes. It is fed by the bot's BasicMux and is safe for
+// concurrent use.
+type Tracker struct {
+	lock sync.RWMutex
+
+	channels map[string]*trackedChannel
+	users    map[string]*trackedUser
+
+	// prefixModes and prefixSymbols come from the PREFIX ISUPPORT token. They
+	// are ordered from highest to lowest.
+	prefixModes   string
+	prefixSymbols string
+
+	// chanModes contains the modes from each of the 4 CHANMODES types.
+	chanModes [4]string
+
+	fold func(string) string
+}
+
+func newTracker() *Tracker {
+	t := &Tracker{
+		channels: make(map[string]*trackedChannel),
+		users:    make(map[string]*trackedUser),
+	}
+
+	t.setISupport(DefaultISupport())
+
+	return t
+}
+
+// setISupport updates the tracker with the values the server sent in
+// RPL_ISUPPORT.
+func (t *Tracker) setISupport(isupport *ISupport) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.prefixModes = isupport.PrefixModes
+	t.prefixSymbols = isupport.PrefixSymbols
+	t.chanModes = isupport.ChanModes
+	t.fold = isupport.ToLower
+}
+
+// trackerCaps are optional caps which make the information in the tracker
+// more complete.
+var trackerCaps = []string{
+	"multi-prefix",
+	"userhost-in-names",
+	"extended-join",
+	"account-notify",
+	"account-tag",
+	"chghost",
+}
+
+func (t *Tracker) register(b *Bot, mux *BasicMux) {
+	for _, name := range trackerCaps {
+		b.CapRequest(name, false)
+	}
+
+	mux.Event("*", t.handleAny)
+	mux.Event("JOIN", t.handleJoin)
+	mux.Event("PART", t.handlePart)
+	mux.Event("KICK", t.handleKick)
+	mux.Event("QUIT", t.handleQuit)
+	mux.Event("NICK", t.handleNick)
+	mux.Event("ACCOUNT", t.handleAccount)
+	mux.Event("CHGHOST", t.handleChghost)
+	mux.Event("MODE", t.handleMode)
+	mux.Event("TOPIC", t.handleTopic)
+	mux.Event(irc.RPL_TOPIC, t.handleRplTopic)
+	mux.Event(irc.RPL_NAMREPLY, t.handleRplNamReply)
+	mux.Event(rplWhoSpcRpl, t.handleRplWhoSpcRpl)
+}
+
+// rplWhoSpcRpl is the reply to a WHOX query. whoxToken is sent with our
+// queries so replies to anyone else's WHO can be ignored.
+const (
+	rplWhoSpcRpl = "354"
+	whoxToken    = "913"
+)
+
+// reset clears all state. This is called whenever a new connection is started.
+func (t *Tracker) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.channels = make(map[string]*trackedChannel)
+	t.users = make(map[string]*trackedUser)
+}
+
+// Channels returns the names of all channels the bot is currently in.
+func (t *Tracker) Channels() []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	ret := make([]string, 0, len(t.channels))
+	for _, c := range t.channels {
+		ret = append(ret, c.name)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// Channel returns information about the given channel. The second return value
+// will be false if the bot is not in that channel.
+func (t *Tracker) Channel(name string) (ChannelInfo, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c, ok := t.channels[t.fold(name)]
+	if !ok {
+		return ChannelInfo{}, false
+	}
+
+	ret := ChannelInfo{
+		Name:  c.name,
+		Topic: c.topic,
+		Users: make(map[string]string, len(c.users)),
+	}
+
+	for key, prefixes := range c.users {
+		ret.Users[t.users[key].nick] = prefixes
+	}
+
+	return ret, true
+}
+
+// User returns information about the given user. The second return value will
+// be false if the user is not in any channel the bot is in.
+func (t *Tracker) User(nick string) (UserInfo, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	u, ok := t.users[t.fold(nick)]
+	if !ok {
+		return UserInfo{}, false
+	}
+
+	ret := UserInfo{
+		Nick:    u.nick,
+		User:    u.user,
+		Host:    u.host,
+		Account: u.account,
+	}
+
+	for key := range u.channels {
+		ret.Channels = append(ret.Channels, t.channels[key].name)
+	}
+
+	sort.Strings(ret.Channels)
+
+	return ret, true
+}
+
+// Prefixes returns the prefixes the given user has in the given channel.
+func (t *Tracker) Prefixes(channel, nick string) string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	c, ok := t.channels[t.fold(channel)]
+	if !ok {
+		return ""
+	}
+
+	return c.users[t.fold(nick)]
+}
+
+// HasPrefix returns true if the user has the given prefix or any higher prefix
+// in the given channel. As an example, HasPrefix(channel, nick, '+') will be
+// true for both voiced users and ops.
+func (t *Tracker) HasPrefix(channel, nick string, prefix rune) bool {
+	prefixes := t.Prefixes(channel, nick)
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	rank := strings.IndexRune(t.prefixSymbols, prefix)
+	if rank < 0 {
+		return false
+	}
+
+	for _, p := range prefixes {
+		if idx := strings.IndexRune(t.prefixSymbols, p); idx >= 0 && idx <= rank {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsOp returns true if the given user is an op (or higher) in the channel.
+func (t *Tracker) IsOp(channel, nick string) bool {
+	return t.HasPrefix(channel, nick, '@')
+}
+
+// IsVoiced returns true if the given user is voiced (or higher) in the
+// channel.
+func (t *Tracker) IsVoiced(channel, nick string) bool {
+	return t.HasPrefix(channel, nick, '+')
+}
+
+// getUser returns the tracked user for the given nick, creating it if needed.
+// It must be called with the lock held.
+func (t *Tracker) getUser(nick string) *trackedUser {
+	key := t.fold(nick)
+
+	u, ok := t.users[key]
+	if !ok {
+		u = &trackedUser{
+			nick:     nick,
+			channels: make(map[string]bool),
+		}
+		t.users[key] = u
+	}
+
+	return u
+}
+
+// updateUser updates the user and host of a user from a message prefix if we
+// are already tracking them. It must be called with the lock held.
+func (t *Tracker) updateUser(p *irc.Prefix) *trackedUser {
+	if p == nil || p.Name == "" {
+		return nil
+	}
+
+	u, ok := t.users[t.fold(p.Name)]
+	if !ok {
+		return nil
+	}
+
+	if p.User != "" {
+		u.user = p.User
+	}
+
+	if p.Host != "" {
+		u.host = p.Host
+	}
+
+	return u
+}
+
+// addMember adds a user to a channel. It must be called with the lock held.
+func (t *Tracker) addMember(c *trackedChannel, nick, prefixes string) *trackedUser {
+	u := t.getUser(nick)
+
+	channelKey := t.fold(c.name)
+	userKey := t.fold(nick)
+
+	u.channels[channelKey] = true
+	c.users[userKey] = prefixes
+
+	return u
+}
+
+// removeMember removes a user from a channel and stops tracking them if they
+// are no longer in any channels. It must be called with the lock held.
+func (t *Tracker) removeMember(channel, nick string) {
+	channelKey := t.fold(channel)
+	userKey := t.fold(nick)
+
+	if c, ok := t.channels[channelKey]; ok {
+		delete(c.users, userKey)
+	}
+
+	if u, ok := t.users[userKey]; ok {
+		delete(u.channels, channelKey)
+
+		if len(u.channels) == 0 {
+			delete(t.users, userKey)
+		}
+	}
+}
+
+// removeChannel stops tracking a channel and all users who were only in that
+// channel. It must be called with the lock held.
+func (t *Tracker) removeChannel(channel string) {
+	c, ok := t.channels[t.fold(channel)]
+	if !ok {
+		return
+	}
+
+	for userKey := range c.users {
+		t.removeMember(c.name, t.users[userKey].nick)
+	}
+
+	delete(t.channels, t.fold(channel))
+}
+
+func (t *Tracker) handleAny(r *Request) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	u := t.updateUser(r.Message.Prefix)
+	if u == nil {
+		return
+	}
+
+	if account, ok := r.Message.Tags.GetTag("account"); ok {
+		u.account = account
+	}
+}
+
+func (t *Tracker) handleJoin(r *Request) {
+	if len(r.Message.Params) < 1 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	name := r.Message.Params[0]
+	key := t.fold(name)
+	nick := r.Message.Prefix.Name
+
+	c, ok := t.channels[key]
+	if !ok {
+		// We only track channels we're in.
+		if t.fold(nick) != t.fold(r.CurrentNick()) {
+			return
+		}
+
+		c = &trackedChannel{
+			name:  name,
+			users: make(map[string]string),
+		}
+		t.channels[key] = c
+	}
+
+	if !ok {
+		// When we join a channel, ask for the account of everyone in it
+		// since there's no other way to find out who is already there.
+		if _, whox := r.ISupport().Raw["WHOX"]; whox {
+			defer r.Writef("WHO %s %%tuhna,%s", name, whoxToken)
+		}
+	}
+
+	u := t.addMember(c, nick, "")
+	t.updateUser(r.Message.Prefix)
+
+	if account, ok := r.Message.Tags.GetTag("account"); ok {
+		u.account = account
+	}
+
+	// With extended-join, the account is the second param.
+	if len(r.Message.Params) > 2 {
+		u.account = r.Message.Params[1]
+		if u.account == "*" {
+			u.account = ""
+		}
+	}
+}
+
+func (t *Tracker) handlePart(r *Request) {
+	if len(r.Message.Params) < 1 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.handleLeave(r.Message.Params[0], r.Message.Prefix.Name, r.CurrentNick())
+}
+
+func (t *Tracker) handleKick(r *Request) {
+	if len(r.Message.Params) < 2 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.handleLeave(r.Message.Params[0], r.Message.Params[1], r.CurrentNick())
+}
+
+// handleLeave deals with a user leaving a channel. It must be called with the
+// lock held.
+func (t *Tracker) handleLeave(channel, nick, currentNick string) {
+	if t.fold(nick) == t.fold(currentNick) {
+		t.removeChannel(channel)
+		return
+	}
+
+	t.removeMember(channel, nick)
+}
+
+func (t *Tracker) handleQuit(r *Request) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	u, ok := t.users[t.fold(r.Message.Prefix.Name)]
+	if !ok {
+		return
+	}
+
+	for channelKey := range u.channels {
+		t.removeMember(t.channels[channelKey].name, u.nick)
+	}
+}
+
+func (t *Tracker) handleNick(r *Request) {
+	if len(r.Message.Params) < 1 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	oldKey := t.fold(r.Message.Prefix.Name)
+	newNick := r.Message.Params[0]
+	newKey := t.fold(newNick)
+
+	u, ok := t.users[oldKey]
+	if !ok {
+		return
+	}
+
+	delete(t.users, oldKey)
+	u.nick = newNick
+	t.users[newKey] = u
+
+	for channelKey := range u.channels {
+		c := t.channels[channelKey]
+		prefixes := c.users[oldKey]
+		delete(c.users, oldKey)
+		c.users[newKey] = prefixes
+	}
+}
+
+func (t *Tracker) handleAccount(r *Request) {
+	if len(r.Message.Params) < 1 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if u, ok := t.users[t.fold(r.Message.Prefix.Name)]; ok {
+		u.account = r.Message.Params[0]
+		if u.account == "*" {
+			u.account = ""
+		}
+	}
+}
+
+// handleRplWhoSpcRpl handles replies to the WHOX query sent when joining a
+// channel. The params are the token, user, host, nick and account.
+func (t *Tracker) handleRplWhoSpcRpl(r *Request) {
+	if len(r.Message.Params) < 6 || r.Message.Params[1] != whoxToken {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	u, ok := t.users[t.fold(r.Message.Params[4])]
+	if !ok {
+		return
+	}
+
+	u.user = r.Message.Params[2]
+	u.host = r.Message.Params[3]
+
+	u.account = r.Message.Params[5]
+	if u.account == "0" {
+		u.account = ""
+	}
+}
+
+func (t *Tracker) handleChghost(r *Request) {
+	if len(r.Message.Params) < 2 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if u, ok := t.users[t.fold(r.Message.Prefix.Name)]; ok {
+		u.user = r.Message.Params[0]
+		u.host = r.Message.Params[1]
+	}
+}
+
+func (t *Tracker) handleTopic(r *Request) {
+	if len(r.Message.Params) < 2 {
+		return
+	}
+
+	t.setTopic(r.Message.Params[0], r.Message.Trailing())
+}
+
+func (t *Tracker) handleRplTopic(r *Request) {
+	if len(r.Message.Params) < 3 {
+		return
+	}
+
+	t.setTopic(r.Message.Params[1], r.Message.Trailing())
+}
+
+func (t *Tracker) setTopic(channel, topic string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if c, ok := t.channels[t.fold(channel)]; ok {
+		c.topic = topic
+	}
+}
+
+func (t *Tracker) handleRplNamReply(r *Request) {
+	if len(r.Message.Params) < 4 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	c, ok := t.channels[t.fold(r.Message.Params[2])]
+	if !ok {
+		return
+	}
+
+	for _, name := range strings.Fields(r.Message.Trailing()) {
+		// Strip off all the prefixes. There will be more than one with
+		// multi-prefix.
+		i := 0
+		for i < len(name) && strings.IndexByte(t.prefixSymbols, name[i]) >= 0 {
+			i++
+		}
+
+		// With userhost-in-names, we get the full prefix here.
+		p := irc.ParsePrefix(name[i:])
+
+		t.addMember(c, p.Name, t.sortPrefixes(name[:i]))
+		t.updateUser(p)
+	}
+}
+
+func (t *Tracker) handleMode(r *Request) {
+	if len(r.Message.Params) < 2 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	c, ok := t.channels[t.fold(r.Message.Params[0])]
+	if !ok {
+		return
+	}
+
+	adding := true
+	args := r.Message.Params[2:]
+
+	for _, mode := range r.Message.Params[1] {
+		switch {
+		case mode == '+':
+			adding = true
+			continue
+		case mode == '-':
+			adding = false
+			continue
+		}
+
+		if !t.modeTakesParam(mode, adding) {
+			continue
+		}
+
+		if len(args) == 0 {
+			return
+		}
+
+		arg := args[0]
+		args = args[1:]
+
+		idx := strings.IndexRune(t.prefixModes, mode)
+		if idx < 0 {
+			continue
+		}
+
+		userKey := t.fold(arg)
+
+		prefixes, ok := c.users[userKey]
+		if !ok {
+			continue
+		}
+
+		symbol := string(t.prefixSymbols[idx])
+
+		if adding {
+			if !strings.Contains(prefixes, symbol) {
+				prefixes = t.sortPrefixes(prefixes + symbol)
+			}
+		} else {
+			prefixes = strings.Replace(prefixes, symbol, "", -1)
+		}
+
+		c.users[userKey] = prefixes
+	}
+}
+
+// modeTakesParam returns true if the given channel mode takes a parameter. It
+// must be called with the lock held.
+func (t *Tracker) modeTakesParam(mode rune, adding bool) bool {
+	switch {
+	case strings.ContainsRune(t.prefixModes, mode):
+		return true
+	case strings.ContainsRune(t.chanModes[0], mode), strings.ContainsRune(t.chanModes[1], mode):
+		return true
+	case strings.ContainsRune(t.chanModes[2], mode):
+		return adding
+	}
+
+	return false
+}
+
+// sortPrefixes orders the given prefixes from highest to lowest. It must be
+// called with the lock held.
+func (t *Tracker) sortPrefixes(prefixes string) string {
+	ret := make([]byte, 0, len(prefixes))
+
+	for i := 0; i < len(t.prefixSymbols); i++ {
+		if strings.IndexByte(prefixes, t.prefixSymbols[i]) >= 0 {
+			ret = append(ret, t.prefixSymbols[i])
+		}
+	}
+
+	return string(ret)
+}
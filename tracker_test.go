@@ -0,0 +1,81 @@
+package seabird_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/belak/go-seabird"
+)
+
+func runTrackerTest(t *testing.T, lines []string) *seabird.Tracker {
+	b := newTestBot(t, "")
+
+	runTestBot(t, b, append([]string{"001 bot :Welcome"}, lines...)...)
+
+	return b.Tracker()
+}
+
+func TestTrackerJoinPart(t *testing.T) {
+	tracker := runTrackerTest(t, []string{
+		":bot!~bot@bot.host JOIN #chan",
+		":bot!~bot@bot.host JOIN #other",
+		"332 bot #chan :A topic",
+		"353 bot = #chan :bot @op +voice @+both regular",
+		":joiner!~j@joiner.host JOIN #chan",
+		":regular!~r@regular.host PART #chan",
+		":op!~o@op.host KICK #chan voice :bye",
+		":bot!~bot@bot.host PART #other",
+	})
+
+	assert.Equal(t, []string{"#chan"}, tracker.Channels())
+
+	info, ok := tracker.Channel("#CHAN")
+	require.True(t, ok)
+	assert.Equal(t, "A topic", info.Topic)
+	assert.Equal(t, map[string]string{
+		"bot":    "",
+		"op":     "@",
+		"both":   "@+",
+		"joiner": "",
+	}, info.Users)
+
+	user, ok := tracker.User("joiner")
+	require.True(t, ok)
+	assert.Equal(t, "~j", user.User)
+	assert.Equal(t, "joiner.host", user.Host)
+	assert.Equal(t, []string{"#chan"}, user.Channels)
+
+	_, ok = tracker.User("regular")
+	assert.False(t, ok)
+}
+
+func TestTrackerModesAndNicks(t *testing.T) {
+	tracker := runTrackerTest(t, []string{
+		"005 bot PREFIX=(qaohv)~&@%+ :are supported by this server",
+		":bot!~bot@bot.host JOIN #chan",
+		"353 bot = #chan :bot ~alice bob carol",
+		":op!~o@op.host MODE #chan +ov-b+l alice bob *!*@bad 10",
+		":op!~o@op.host MODE #chan +k-o key alice",
+		":bob!~b@bob.host NICK robert",
+		":carol!~c@carol.host QUIT :bye",
+		":robert!~b@bob.host ACCOUNT robert",
+	})
+
+	assert.True(t, tracker.IsOp("#chan", "alice"))
+	assert.Equal(t, "~", tracker.Prefixes("#chan", "alice"))
+	assert.True(t, tracker.IsVoiced("#chan", "robert"))
+	assert.False(t, tracker.IsOp("#chan", "robert"))
+	assert.Equal(t, "+", tracker.Prefixes("#chan", "Robert"))
+
+	user, ok := tracker.User("robert")
+	require.True(t, ok)
+	assert.Equal(t, "robert", user.Account)
+
+	_, ok = tracker.User("bob")
+	assert.False(t, ok)
+
+	_, ok = tracker.User("carol")
+	assert.False(t, ok)
+}